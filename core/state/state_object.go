@@ -214,6 +214,18 @@ func (self *stateObject) setState(key, value common.Hash) {
 	self.dirtyStorage[key] = value
 }
 
+// SetStorage replaces the entire storage of the object with the given entries.
+// The change is not journaled and cannot be reverted.
+func (self *stateObject) SetStorage(storage map[common.Hash]common.Hash) {
+	self.data.Root = common.Hash{}
+	self.trie = nil
+	self.originStorage = make(Storage)
+	self.dirtyStorage = make(Storage)
+	for key, value := range storage {
+		self.dirtyStorage[key] = value
+	}
+}
+
 // updateTrie writes cached storage modifications into the object's storage trie.
 func (self *stateObject) updateTrie(db Database) Trie {
 	tr := self.getTrie(db)
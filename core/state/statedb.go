@@ -360,6 +360,17 @@ func (self *StateDB) SetState(addr common.Address, key, value common.Hash) {
 	}
 }
 
+// SetStorage replaces the entire storage of the given account, e.g. to override
+// contract state when simulating a call. The change is not journaled and can
+// not be reverted to an earlier snapshot.
+func (self *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common.Hash) {
+	stateObject := self.GetOrNewStateObject(addr)
+	if stateObject != nil {
+		stateObject.SetStorage(storage)
+		self.journal.dirty(addr)
+	}
+}
+
 // Suicide marks the given account as suicided.
 // This clears the account balance.
 //
@@ -435,3 +435,34 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// TestSetStorage tests that replacing the storage of an account discards all
+// previous entries, is carried over to copies and yields the same root as a
+// state created with the new storage.
+func TestSetStorage(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	addr := common.HexToAddress("aaaa")
+	key1, key2 := common.HexToHash("01"), common.HexToHash("02")
+
+	sdb, _ := New(common.Hash{}, db)
+	sdb.SetState(addr, key1, common.HexToHash("11"))
+	root, _ := sdb.Commit(false)
+
+	sdb, _ = New(root, db)
+	sdb.SetStorage(addr, map[common.Hash]common.Hash{key2: common.HexToHash("22")})
+
+	for i, state := range []*StateDB{sdb, sdb.Copy()} {
+		if got := state.GetState(addr, key1); got != (common.Hash{}) {
+			t.Errorf("state %d: replaced entry not cleared: have %x", i, got)
+		}
+		if got, want := state.GetState(addr, key2), common.HexToHash("22"); got != want {
+			t.Errorf("state %d: new entry mismatch: have %x, want %x", i, got, want)
+		}
+	}
+	want, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	want.SetState(addr, key2, common.HexToHash("22"))
+
+	if have, want := sdb.IntermediateRoot(false), want.IntermediateRoot(false); have != want {
+		t.Errorf("root mismatch: have %x, want %x", have, want)
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -683,22 +684,121 @@ type CallArgs struct {
 	Data     hexutil.Bytes   `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
+// sender returns the sender of the call, defaulting to the first account of
+// the node's first wallet if none was specified.
+func (args *CallArgs) sender(b Backend) common.Address {
+	if args.From != (common.Address{}) {
+		return args.From
+	}
+	if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
+		if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+			return accounts[0].Address
+		}
+	}
+	return common.Address{}
+}
+
+// OverrideAccount specifies the fields of an account to override when
+// executing a call. State replaces the entire storage of the account, while
+// StateDiff only replaces the given storage slots.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64              `json:"nonce"`
+	Code      *hexutil.Bytes               `json:"code"`
+	Balance   *hexutil.Big                 `json:"balance"`
+	State     *map[common.Hash]common.Hash `json:"state"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
+}
+
+// StateOverride is the set of accounts to override, keyed by address.
+type StateOverride map[common.Address]OverrideAccount
+
+// apply overrides the accounts in the given state.
+func (diff StateOverride) apply(statedb *state.StateDB) error {
+	for addr, account := range diff {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+		if account.Nonce != nil {
+			statedb.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			statedb.SetCode(addr, *account.Code)
+		}
+		if account.Balance != nil {
+			statedb.SetBalance(addr, (*big.Int)(account.Balance))
+		}
+		if account.State != nil {
+			statedb.SetStorage(addr, *account.State)
+		}
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				statedb.SetState(addr, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// BlockOverrides is a set of block environment fields to override when executing
+// a call, allowing block dependent contract logic to be simulated deterministically.
+// The call is executed under the chain rules of the overridden block number, while
+// BLOCKHASH only resolves the 256 blocks preceding the real block. The hashes of
+// all other blocks read as zero.
+type BlockOverrides struct {
+	Number     *hexutil.Big    `json:"number"`
+	Time       *hexutil.Big    `json:"time"`
+	Coinbase   *common.Address `json:"coinbase"`
+	GasLimit   *hexutil.Uint64 `json:"gasLimit"`
+	Difficulty *hexutil.Big    `json:"difficulty"`
+}
+
+// apply returns an EVM executing on the state of the given one, in its block
+// environment with the overrides applied. The given EVM must be created from the
+// unmodified header, as the consensus engine derives the block author from its
+// seal. The returned EVM follows the chain rules of the overridden block number.
+func (o *BlockOverrides) apply(evm *vm.EVM, config *params.ChainConfig, vmCfg vm.Config) *vm.EVM {
+	if o == nil {
+		return evm
+	}
+	context := evm.Context
+	if o.Number != nil {
+		// Only serve the hashes of real blocks below the real head, anything else
+		// would walk the entire chain within a single, uncancellable opcode.
+		head, getHash := context.BlockNumber.Uint64(), context.GetHash
+		context.GetHash = func(n uint64) common.Hash {
+			if n >= head || head-n > 256 {
+				return common.Hash{}
+			}
+			return getHash(n)
+		}
+		context.BlockNumber = new(big.Int).Set(o.Number.ToInt())
+	}
+	if o.Time != nil {
+		context.Time = new(big.Int).Set(o.Time.ToInt())
+	}
+	if o.Coinbase != nil {
+		context.Coinbase = *o.Coinbase
+	}
+	if o.GasLimit != nil {
+		context.GasLimit = uint64(*o.GasLimit)
+	}
+	if o.Difficulty != nil {
+		context.Difficulty = new(big.Int).Set(o.Difficulty.ToInt())
+	}
+	return vm.NewEVM(context, evm.StateDB, config, vmCfg)
+}
+
+func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, blockOverrides *BlockOverrides, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
 	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
 
 	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
 		return nil, 0, false, err
 	}
+
 	// Set sender address or use a default if none specified
-	addr := args.From
-	if addr == (common.Address{}) {
-		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
-			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
-				addr = accounts[0].Address
-			}
-		}
-	}
+	addr := args.sender(s.b)
+
 	// Set default gas & gas price if none were set
 	gas, gasPrice := uint64(args.Gas), args.GasPrice.ToInt()
 	if gas == 0 {
@@ -728,6 +828,8 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	if err != nil {
 		return nil, 0, false, err
 	}
+	evm = blockOverrides.apply(evm, s.b.ChainConfig(), vmCfg)
+
 	// Wait for the context to be done and cancel the evm. Even if the
 	// EVM has finished, cancelling may be done (repeatedly)
 	go func() {
@@ -745,13 +847,129 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	return res, gas, failed, err
 }
 
+// applyCall executes the given message on the given state and keeps its state
+// changes, as if it was the next transaction in the block. The sender is funded
+// for the duration of the call, afterwards only the ether it actually spent is
+// deducted from its original balance. If the call can't be executed, the state
+// is left unmodified. The optional block overrides are applied on top of the
+// environment of the given block.
+func applyCall(ctx context.Context, b Backend, msg types.Message, statedb *state.StateDB, header *types.Header, blockOverrides *BlockOverrides) ([]byte, uint64, bool, error) {
+	// Remember the sender's balance, the EVM funds it for the call
+	balance := new(big.Int).Set(statedb.GetBalance(msg.From()))
+	snapshot := statedb.Snapshot()
+
+	evm, vmError, err := b.GetEVM(ctx, msg, statedb, header, vm.Config{})
+	if err != nil {
+		statedb.RevertToSnapshot(snapshot)
+		return nil, 0, false, err
+	}
+	evm = blockOverrides.apply(evm, b.ChainConfig(), vm.Config{})
+	funded := new(big.Int).Set(statedb.GetBalance(msg.From()))
+
+	// Wait for the context to be done and cancel the evm
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	res, gas, failed, err := core.ApplyMessage(evm, msg, gp)
+	if err == nil {
+		err = vmError()
+	}
+	if err == nil && ctx.Err() == context.DeadlineExceeded {
+		err = errCallTimeout
+	}
+	if err != nil {
+		statedb.RevertToSnapshot(snapshot)
+		return nil, 0, false, err
+	}
+	// Charge the sender for what the call actually spent
+	spent := new(big.Int).Sub(funded, statedb.GetBalance(msg.From()))
+	balance.Sub(balance, spent)
+	if balance.Sign() < 0 {
+		statedb.RevertToSnapshot(snapshot)
+		return nil, 0, false, core.ErrInsufficientFunds
+	}
+	statedb.SetBalance(msg.From(), balance)
+	statedb.Finalise(b.ChainConfig().IsEIP158(evm.BlockNumber))
+
+	return res, gas, failed, nil
+}
+
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{}, 5*time.Second)
+//
+// The optional block overrides replace fields of the block environment the call
+// is executed in, e.g. moving the timestamp past a deadline or time lock, or
+// pinning the difficulty that randomness dependent contracts read via the
+// DIFFICULTY opcode.
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, blockOverrides *BlockOverrides) (hexutil.Bytes, error) {
+	result, _, _, err := s.doCall(ctx, args, blockNr, blockOverrides, vm.Config{}, 5*time.Second)
 	return (hexutil.Bytes)(result), err
 }
 
+// CallManyArgs represents a single call of a call batch, along with the state
+// and block overrides to apply before executing it.
+type CallManyArgs struct {
+	CallArgs
+	StateOverrides StateOverride   `json:"stateOverrides"`
+	BlockOverrides *BlockOverrides `json:"blockOverrides"`
+}
+
+// CallManyResult is the outcome of a single call of a call batch. If the call
+// could not be executed, the error is reported and the remaining fields are
+// omitted.
+type CallManyResult struct {
+	ReturnValue hexutil.Bytes  `json:"returnValue,omitempty"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Failed      bool           `json:"failed,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// CallMany executes the given calls one after the other on the state for the
+// given block number. Each call sees the state changes of the calls before it,
+// including their state overrides, but nothing is written to the chain. The
+// gas price defaults to zero, so unpriced calls don't alter the balance of the
+// sender seen by later calls.
+func (s *PublicBlockChainAPI) CallMany(ctx context.Context, calls []CallManyArgs, blockNr rpc.BlockNumber) ([]*CallManyResult, error) {
+	defer func(start time.Time) { log.Debug("Executing EVM call batch finished", "runtime", time.Since(start)) }(time.Now())
+
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	// All calls of the batch share a single timeout
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	results := make([]*CallManyResult, len(calls))
+	for i, call := range calls {
+		if err := call.StateOverrides.apply(state); err != nil {
+			return nil, err
+		}
+		gas := uint64(call.Gas)
+		if gas == 0 {
+			gas = math.MaxUint64 / 2
+		}
+		msg := types.NewMessage(call.sender(s.b), call.To, 0, call.Value.ToInt(), gas, call.GasPrice.ToInt(), call.Data, false)
+
+		res, gas, failed, err := applyCall(ctx, s.b, msg, state, header, call.BlockOverrides)
+		if err == errCallTimeout {
+			return nil, err
+		}
+		if err != nil {
+			results[i] = &CallManyResult{Error: err.Error()}
+			continue
+		}
+		results[i] = &CallManyResult{
+			ReturnValue: res,
+			GasUsed:     hexutil.Uint64(gas),
+			Failed:      failed,
+		}
+	}
+	return results, nil
+}
+
 // EstimateGas returns an estimate of the amount of gas needed to execute the
 // given transaction against the current pending block.
 func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
@@ -777,7 +995,7 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	executable := func(gas uint64) bool {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{}, 0)
+		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, nil, vm.Config{}, 0)
 		if err != nil || failed {
 			return false
 		}
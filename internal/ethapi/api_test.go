@@ -0,0 +1,318 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testEnvironmentCode returns the block number, timestamp, coinbase, difficulty
+// and gas limit seen by the executing code as five ABI words.
+var testEnvironmentCode = common.FromHex("436000524260205241604052446060524560805260a06000f3")
+
+func TestCallBlockOverrides(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xe0")
+		coinbase = common.HexToAddress("0xc0ffee")
+	)
+	b := newTestBackend(t, core.GenesisAlloc{contract: {Code: testEnvironmentCode}})
+	api := NewPublicBlockChainAPI(b)
+
+	word := func(x uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(x)) }
+	tests := []struct {
+		overrides *BlockOverrides
+		want      []common.Hash
+	}{
+		// No overrides execute in the environment of the real block
+		{
+			overrides: nil,
+			want: []common.Hash{
+				word(b.header.Number.Uint64()), word(b.header.Time.Uint64()), common.BytesToHash(b.header.Coinbase.Bytes()),
+				word(b.header.Difficulty.Uint64()), word(b.header.GasLimit),
+			},
+		},
+		// Every override replaces the value seen by the executing code
+		{
+			overrides: &BlockOverrides{
+				Number:     (*hexutil.Big)(big.NewInt(100)),
+				Time:       (*hexutil.Big)(big.NewInt(1546300800)),
+				Coinbase:   &coinbase,
+				GasLimit:   (*hexutil.Uint64)(new(uint64)),
+				Difficulty: (*hexutil.Big)(big.NewInt(42)),
+			},
+			want: []common.Hash{word(100), word(1546300800), common.BytesToHash(coinbase.Bytes()), word(42), word(0)},
+		},
+		// Fields without override keep the value of the real block
+		{
+			overrides: &BlockOverrides{Coinbase: &coinbase},
+			want: []common.Hash{
+				word(b.header.Number.Uint64()), word(b.header.Time.Uint64()), common.BytesToHash(coinbase.Bytes()),
+				word(b.header.Difficulty.Uint64()), word(b.header.GasLimit),
+			},
+		},
+	}
+	for i, tt := range tests {
+		ret, err := api.Call(context.Background(), CallArgs{From: common.HexToAddress("0x5e"), To: &contract}, rpc.LatestBlockNumber, tt.overrides)
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if len(ret) != 32*len(tt.want) {
+			t.Fatalf("test %d: return length mismatch: have %d, want %d", i, len(ret), 32*len(tt.want))
+		}
+		for j, want := range tt.want {
+			if have := common.BytesToHash(ret[32*j : 32*(j+1)]); have != want {
+				t.Errorf("test %d, field %d: value mismatch: have %x, want %x", i, j, have, want)
+			}
+		}
+	}
+}
+
+func TestApplyCall(t *testing.T) {
+	var (
+		sender    = common.HexToAddress("0x5e")
+		recipient = common.HexToAddress("0xbe")
+		reverter  = common.HexToAddress("0xfd")
+		ether     = big.NewInt(params.Ether)
+	)
+	tests := []struct {
+		balance  *big.Int
+		to       common.Address
+		value    int64
+		gas      uint64
+		gasPrice int64
+
+		err      error
+		failed   bool
+		gasUsed  uint64
+		sender   *big.Int // expected sender balance after the call
+		received int64    // expected recipient balance after the call
+	}{
+		// The sender is charged for the value and gas actually spent
+		{balance: ether, to: recipient, value: 1000, gas: 100000, gasPrice: 2, gasUsed: params.TxGas,
+			sender: new(big.Int).Sub(ether, big.NewInt(1000+2*int64(params.TxGas))), received: 1000},
+		// Unpriced calls only spend the transferred value
+		{balance: ether, to: recipient, value: 1000, gas: 100000, gasUsed: params.TxGas,
+			sender: new(big.Int).Sub(ether, big.NewInt(1000)), received: 1000},
+		// Failed executions are charged for their gas, but transfer nothing
+		{balance: ether, to: reverter, value: 1000, gas: 100000, gasPrice: 1, failed: true, gasUsed: params.TxGas + 6,
+			sender: new(big.Int).Sub(ether, big.NewInt(int64(params.TxGas)+6))},
+		// Spending more than the sender owns leaves the state untouched
+		{balance: big.NewInt(999), to: recipient, value: 1000, gas: 100000, err: core.ErrInsufficientFunds, sender: big.NewInt(999)},
+		{balance: big.NewInt(1000), to: recipient, value: 1000, gas: 100000, gasPrice: 1, err: core.ErrInsufficientFunds, sender: big.NewInt(1000)},
+		// Messages that can't be executed leave the state untouched
+		{balance: ether, to: recipient, value: 1000, gas: params.TxGas - 1, err: vm.ErrOutOfGas, sender: ether},
+	}
+	for i, tt := range tests {
+		b := newTestBackend(t, core.GenesisAlloc{
+			sender:   {Balance: tt.balance},
+			reverter: {Code: common.FromHex("60006000fd")},
+		})
+		statedb, header, _ := b.StateAndHeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+
+		msg := types.NewMessage(sender, &tt.to, 0, big.NewInt(tt.value), tt.gas, big.NewInt(tt.gasPrice), nil, false)
+		_, gasUsed, failed, err := applyCall(context.Background(), b, msg, statedb, header, nil)
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if failed != tt.failed {
+			t.Errorf("test %d: failure mismatch: have %v, want %v", i, failed, tt.failed)
+		}
+		if gasUsed != tt.gasUsed {
+			t.Errorf("test %d: gas used mismatch: have %d, want %d", i, gasUsed, tt.gasUsed)
+		}
+		if have := statedb.GetBalance(sender); have.Cmp(tt.sender) != 0 {
+			t.Errorf("test %d: sender balance mismatch: have %v, want %v", i, have, tt.sender)
+		}
+		if have := statedb.GetBalance(tt.to); have.Cmp(big.NewInt(tt.received)) != 0 {
+			t.Errorf("test %d: recipient balance mismatch: have %v, want %v", i, have, tt.received)
+		}
+	}
+}
+
+func TestCallManyOverrides(t *testing.T) {
+	var (
+		sender  = common.HexToAddress("0x5e")
+		counter = common.HexToAddress("0xc0")
+		slot    = common.Hash{}
+	)
+	api := NewPublicBlockChainAPI(newTestBackend(t, core.GenesisAlloc{
+		counter: {Code: testCounterCode, Storage: map[common.Hash]common.Hash{slot: common.BigToHash(big.NewInt(10))}},
+	}))
+	var (
+		diff  = map[common.Hash]common.Hash{slot: common.BigToHash(big.NewInt(5))}
+		empty = map[common.Hash]common.Hash{}
+	)
+	calls := []CallManyArgs{
+		// Calls build on the chain state and on each other
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+		// Overrides apply on top of the changes of earlier calls and persist
+		{CallArgs: CallArgs{From: sender, To: &counter}, StateOverrides: StateOverride{counter: {StateDiff: &diff}}},
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+		{CallArgs: CallArgs{From: sender, To: &counter}, StateOverrides: StateOverride{counter: {State: &empty}}},
+		// Failing calls are reported without aborting the batch
+		{CallArgs: CallArgs{From: sender, To: &counter, Gas: hexutil.Uint64(params.TxGas - 1)}},
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+	}
+	want := []int64{11, 12, 6, 7, 1, -1, 2}
+
+	results, err := api.CallMany(context.Background(), calls, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("call batch failed: %v", err)
+	}
+	if len(results) != len(want) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(want))
+	}
+	for i, res := range results {
+		if want[i] < 0 {
+			if res.Error != vm.ErrOutOfGas.Error() {
+				t.Errorf("call %d: error mismatch: have %q, want %q", i, res.Error, vm.ErrOutOfGas)
+			}
+			continue
+		}
+		if res.Error != "" || res.Failed {
+			t.Errorf("call %d: failed: %q", i, res.Error)
+			continue
+		}
+		if have := new(big.Int).SetBytes(res.ReturnValue); have.Int64() != want[i] {
+			t.Errorf("call %d: counter mismatch: have %d, want %d", i, have, want[i])
+		}
+	}
+}
+
+func TestCallManyTimeout(t *testing.T) {
+	var (
+		sender  = common.HexToAddress("0x5e")
+		counter = common.HexToAddress("0xc0")
+		looper  = common.HexToAddress("0x100")
+	)
+	api := NewPublicBlockChainAPI(newTestBackend(t, core.GenesisAlloc{
+		counter: {Code: testCounterCode},
+		looper:  {Code: common.FromHex("5b600056")}, // JUMPDEST PUSH1 0 JUMP
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The calls before the endless loop succeed, yet the whole batch must fail
+	calls := []CallManyArgs{
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+		{CallArgs: CallArgs{From: sender, To: &looper}},
+		{CallArgs: CallArgs{From: sender, To: &counter}},
+	}
+	results, err := api.CallMany(ctx, calls, rpc.LatestBlockNumber)
+	if err != errCallTimeout {
+		t.Fatalf("error mismatch: have %v, want %v", err, errCallTimeout)
+	}
+	if results != nil {
+		t.Fatalf("results returned for timed out batch: %v", results)
+	}
+}
+
+func TestCallBlockHashOverrides(t *testing.T) {
+	contract := common.HexToAddress("0xb0")
+
+	// BLOCKHASH(calldataload(0))
+	b := newTestChain(t, core.GenesisAlloc{contract: {Code: common.FromHex("6000354060005260206000f3")}}, 300)
+	api := NewPublicBlockChainAPI(b)
+
+	hashes := make(map[uint64]common.Hash)
+	for hash, header := range b.headers {
+		hashes[header.Number.Uint64()] = hash
+	}
+	tests := []struct {
+		number *big.Int // overridden block number, nil for none
+		block  uint64
+		want   common.Hash
+		walk   bool // whether the chain may be walked to resolve the hash
+	}{
+		// Without overrides the blocks preceding the head resolve
+		{block: 298, want: hashes[298]},
+		{block: 100, want: hashes[100], walk: true},
+		{block: 299},
+
+		// Made up blocks above the head never walk the chain
+		{number: big.NewInt(1000), block: 500},
+		{number: big.NewInt(1000), block: 999},
+		{number: big.NewInt(1000), block: 299},
+
+		// Real blocks only resolve within 256 blocks of the real head
+		{number: big.NewInt(250), block: 100, want: hashes[100], walk: true},
+		{number: big.NewInt(250), block: 20},
+	}
+	for i, tt := range tests {
+		var overrides *BlockOverrides
+		if tt.number != nil {
+			overrides = &BlockOverrides{Number: (*hexutil.Big)(tt.number)}
+		}
+		input := hexutil.Bytes(abiWord(new(big.Int).SetUint64(tt.block)))
+
+		b.lookups = 0
+		ret, err := api.Call(context.Background(), CallArgs{From: common.HexToAddress("0x5e"), To: &contract, Data: input}, rpc.LatestBlockNumber, overrides)
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if have := common.BytesToHash(ret); have != tt.want {
+			t.Errorf("test %d: hash mismatch: have %x, want %x", i, have, tt.want)
+		}
+		if !tt.walk && b.lookups > 0 {
+			t.Errorf("test %d: chain walked for %d headers", i, b.lookups)
+		}
+	}
+}
+
+func TestCallBlockNumberRules(t *testing.T) {
+	contract := common.HexToAddress("0x3d")
+
+	// RETURNDATASIZE, only available since Byzantium
+	b := newTestChain(t, core.GenesisAlloc{contract: {Code: common.FromHex("3d60005260206000f3")}}, 200)
+	b.config.ByzantiumBlock = big.NewInt(100)
+	b.config.ConstantinopleBlock = big.NewInt(100)
+	api := NewPublicBlockChainAPI(b)
+
+	tests := []struct {
+		number *big.Int
+		ok     bool
+	}{
+		{number: nil, ok: true},
+		{number: big.NewInt(99), ok: false},
+		{number: big.NewInt(100), ok: true},
+	}
+	for i, tt := range tests {
+		var overrides *BlockOverrides
+		if tt.number != nil {
+			overrides = &BlockOverrides{Number: (*hexutil.Big)(tt.number)}
+		}
+		ret, err := api.Call(context.Background(), CallArgs{From: common.HexToAddress("0x5e"), To: &contract}, rpc.LatestBlockNumber, overrides)
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if ok := len(ret) == 32; ok != tt.ok {
+			t.Errorf("test %d: execution mismatch: have %v, want %v", i, ok, tt.ok)
+		}
+	}
+}
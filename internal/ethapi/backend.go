@@ -113,6 +113,16 @@ func GetAPIs(apiBackend Backend) []rpc.API {
 			Version:   "1.0",
 			Service:   NewPrivateAccountAPI(apiBackend, nonceLock),
 			Public:    false,
+		}, {
+			Namespace: "relay",
+			Version:   "1.0",
+			Service:   NewPrivateRelayAPI(apiBackend),
+			Public:    false,
+		}, {
+			Namespace: "gnosis",
+			Version:   "1.0",
+			Service:   NewPublicGnosisAPI(apiBackend),
+			Public:    true,
 		},
 	}
 }
@@ -0,0 +1,166 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testBackend is a minimal Backend serving the state of a single block held
+// in memory. Methods not needed by the tests are left unimplemented.
+type testBackend struct {
+	Backend
+
+	db     state.Database
+	header *types.Header
+	config *params.ChainConfig
+
+	headers map[common.Hash]*types.Header // chain of headers leading to header
+	lookups int                           // number of headers retrieved by the EVM
+
+	pool   types.Transactions // pending transactions of the pool
+	txFeed event.Feed
+}
+
+// newTestBackend creates a backend whose only block contains the given accounts.
+func newTestBackend(t *testing.T, alloc core.GenesisAlloc) *testBackend {
+	db := state.NewDatabase(ethdb.NewMemDatabase())
+	statedb, _ := state.New(common.Hash{}, db)
+	for addr, account := range alloc {
+		if account.Balance != nil {
+			statedb.SetBalance(addr, account.Balance)
+		}
+		statedb.SetCode(addr, account.Code)
+		statedb.SetNonce(addr, account.Nonce)
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit test state: %v", err)
+	}
+	// Enable all forks, including Constantinople for CREATE2
+	config := *params.AllEthashProtocolChanges
+	config.ConstantinopleBlock = big.NewInt(0)
+
+	return &testBackend{
+		db: db,
+		header: &types.Header{
+			Number:     big.NewInt(1),
+			Time:       big.NewInt(1),
+			Difficulty: big.NewInt(1),
+			GasLimit:   params.GenesisGasLimit,
+			Root:       root,
+		},
+		config: &config,
+	}
+}
+
+func (b *testBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	header, _ := b.HeaderByNumber(ctx, blockNr)
+	if header == nil {
+		return nil, nil, nil
+	}
+	statedb, err := state.New(header.Root, b.db)
+	return statedb, header, err
+}
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error) {
+	if blockNr == rpc.LatestBlockNumber || blockNr == rpc.PendingBlockNumber {
+		return b.header, nil
+	}
+	for _, header := range b.headers {
+		if header.Number.Int64() == int64(blockNr) {
+			return header, nil
+		}
+	}
+	if b.header.Number.Int64() == int64(blockNr) {
+		return b.header, nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error) {
+	state.SetBalance(msg.From(), math.MaxBig256)
+	vmError := func() error { return nil }
+
+	context := core.NewEVMContext(msg, header, b, nil)
+	return vm.NewEVM(context, state, b.config, vmCfg), vmError, nil
+}
+
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return b.config
+}
+
+func (b *testBackend) GetPoolTransactions() (types.Transactions, error) {
+	return b.pool, nil
+}
+
+func (b *testBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return b.txFeed.Subscribe(ch)
+}
+
+// Engine implements core.ChainContext.
+func (b *testBackend) Engine() consensus.Engine {
+	return ethash.NewFaker()
+}
+
+// GetHeader implements core.ChainContext.
+func (b *testBackend) GetHeader(hash common.Hash, number uint64) *types.Header {
+	b.lookups++
+	if header := b.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// newTestChain creates a backend whose head is the last of the given number of
+// chained blocks, all containing the given accounts.
+func newTestChain(t *testing.T, alloc core.GenesisAlloc, blocks int) *testBackend {
+	b := newTestBackend(t, alloc)
+	b.headers = make(map[common.Hash]*types.Header)
+
+	var parent common.Hash
+	for i := 0; i < blocks; i++ {
+		header := types.CopyHeader(b.header)
+		header.Number = big.NewInt(int64(i))
+		header.ParentHash = parent
+		parent = header.Hash()
+
+		b.headers[parent] = header
+		if i == blocks-1 {
+			b.header = header
+		}
+	}
+	return b
+}
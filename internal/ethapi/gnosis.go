@@ -0,0 +1,727 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Storage layout shared by all Gnosis Safe versions (1.0.0 and later). Owners
+// and modules are kept in linked lists rooted at the sentinel address.
+var (
+	safeSingletonSlot = common.BigToHash(big.NewInt(0))
+	safeModulesSlot   = common.BigToHash(big.NewInt(1))
+	safeOwnersSlot    = common.BigToHash(big.NewInt(2))
+	safeThresholdSlot = common.BigToHash(big.NewInt(4))
+	safeNonceSlot     = common.BigToHash(big.NewInt(5))
+
+	// Fixed slots of the fallback handler (1.1.0 and later) and the
+	// transaction guard (1.3.0 and later).
+	safeFallbackHandlerSlot = crypto.Keccak256Hash([]byte("fallback_manager.handler.address"))
+	safeGuardSlot           = crypto.Keccak256Hash([]byte("guard_manager.guard.address"))
+
+	safeSentinel = common.BigToAddress(common.Big1)
+)
+
+// Selectors used to identify well known Safe modules. Modules following the
+// Safe module conventions expose their name, 4337 modules expose the entry
+// point they support.
+var (
+	moduleNameSelector       = crypto.Keccak256([]byte("NAME()"))[:4]
+	moduleEntryPointSelector = crypto.Keccak256([]byte("SUPPORTED_ENTRYPOINT()"))[:4]
+)
+
+// Names reported by the NAME() function of well known Safe modules.
+const (
+	allowanceModuleName      = "Allowance Module"
+	socialRecoveryModuleName = "Social Recovery Module"
+)
+
+// proxyCreationCodeSelector is the function selector of the proxy factory method
+// returning the creation code of the deployed proxies.
+var proxyCreationCodeSelector = crypto.Keccak256([]byte("proxyCreationCode()"))[:4]
+
+// execTransactionSelector is the function selector of Safe.execTransaction,
+// which is the same for all Safe versions.
+var execTransactionSelector = crypto.Keccak256([]byte("execTransaction(address,uint256,bytes,uint8,uint256,uint256,uint256,address,address,bytes)"))[:4]
+
+// txChanSize is the size of channel listening to NewTxsEvent.
+const txChanSize = 4096
+
+const (
+	// nonceConflictTimeout is the total time allowed for simulating the pending
+	// Safe executions of a single nonce conflict check.
+	nonceConflictTimeout = 5 * time.Second
+
+	// maxSafeExecutions is the maximum number of pending executions simulated
+	// per Safe. The executions the miner would include first are checked, since
+	// only those have a chance to win the conflict.
+	maxSafeExecutions = 16
+)
+
+const (
+	// maxBalanceHistoryPoints is the maximum number of balances returned by a
+	// single balance history query.
+	maxBalanceHistoryPoints = 1024
+
+	// balanceCacheLimit is the number of historical balances kept in memory.
+	balanceCacheLimit = 16384
+)
+
+// maxSafeListLength caps the number of entries read from a Safe's owner or
+// module list, protecting against corrupted (cyclic) lists.
+const maxSafeListLength = 1024
+
+var (
+	errNotSafe                  = errors.New("account is not an initialized Safe")
+	errInvalidModuleStart       = errors.New("start is neither the sentinel nor an enabled module")
+	errTooManyBalancePoints     = fmt.Errorf("balance history exceeds %d points", maxBalanceHistoryPoints)
+	errInvalidDynamicReturnData = errors.New("invalid dynamic return data")
+)
+
+// safeMappingSlot returns the storage slot of the given key in a Solidity
+// mapping(address => address) located at the given slot.
+func safeMappingSlot(slot common.Hash, key common.Address) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(key.Bytes(), 32), slot.Bytes())
+}
+
+// readSafeList walks a Safe linked list starting after the given entry and
+// returns at most limit entries, as well as the last returned entry to continue
+// from (or the sentinel if no entries follow it).
+func readSafeList(statedb *state.StateDB, safe common.Address, slot common.Hash, start common.Address, limit int) ([]common.Address, common.Address) {
+	var (
+		entries = []common.Address{}
+		current = start
+	)
+	for len(entries) < limit {
+		next := common.BytesToAddress(statedb.GetState(safe, safeMappingSlot(slot, current)).Bytes())
+		if next == (common.Address{}) || next == safeSentinel {
+			return entries, safeSentinel
+		}
+		entries = append(entries, next)
+		current = next
+	}
+	// Report the end of the list even if it coincides with the page boundary
+	next := common.BytesToAddress(statedb.GetState(safe, safeMappingSlot(slot, current)).Bytes())
+	if next == (common.Address{}) || next == safeSentinel {
+		return entries, safeSentinel
+	}
+	return entries, current
+}
+
+// decodeABIBytes decodes the ABI encoding of a single dynamic bytes or string
+// value.
+func decodeABIBytes(ret []byte) ([]byte, error) {
+	if len(ret) < 64 {
+		return nil, errInvalidDynamicReturnData
+	}
+	offset := new(big.Int).SetBytes(ret[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(ret)-32) {
+		return nil, errInvalidDynamicReturnData
+	}
+	start := offset.Uint64() + 32
+	length := new(big.Int).SetBytes(ret[start-32 : start])
+	if !length.IsUint64() || length.Uint64() > uint64(len(ret))-start {
+		return nil, errInvalidDynamicReturnData
+	}
+	return ret[start : start+length.Uint64()], nil
+}
+
+// PublicGnosisAPI provides an API to inspect Gnosis Safe contracts.
+type PublicGnosisAPI struct {
+	b            Backend
+	balanceCache *lru.Cache // Historical balances keyed by balanceCacheKey
+}
+
+// NewPublicGnosisAPI creates a new Gnosis Safe API.
+func NewPublicGnosisAPI(b Backend) *PublicGnosisAPI {
+	balanceCache, _ := lru.New(balanceCacheLimit)
+	return &PublicGnosisAPI{
+		b:            b,
+		balanceCache: balanceCache,
+	}
+}
+
+// SafeInfo is the configuration of a Safe at a given block.
+type SafeInfo struct {
+	Address         common.Address   `json:"address"`
+	Singleton       common.Address   `json:"singleton"`
+	Owners          []common.Address `json:"owners"`
+	Threshold       hexutil.Uint64   `json:"threshold"`
+	Nonce           *hexutil.Big     `json:"nonce"`
+	FallbackHandler common.Address   `json:"fallbackHandler"`
+	Guard           common.Address   `json:"guard"`
+	Modules         []common.Address `json:"modules"`
+}
+
+// GetSafeInfo returns the owners, threshold, nonce, singleton, fallback handler,
+// guard and enabled modules of the given Safe. All values are read directly
+// from the Safe's storage, so no contract code is executed.
+func (s *PublicGnosisAPI) GetSafeInfo(ctx context.Context, safe common.Address, blockNr rpc.BlockNumber) (*SafeInfo, error) {
+	statedb, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	threshold := statedb.GetState(safe, safeThresholdSlot).Big()
+	if threshold.Sign() == 0 {
+		if err := statedb.Error(); err != nil {
+			return nil, err
+		}
+		return nil, errNotSafe
+	}
+	owners, _ := readSafeList(statedb, safe, safeOwnersSlot, safeSentinel, maxSafeListLength)
+	modules, _ := readSafeList(statedb, safe, safeModulesSlot, safeSentinel, maxSafeListLength)
+
+	return &SafeInfo{
+		Address:         safe,
+		Singleton:       common.BytesToAddress(statedb.GetState(safe, safeSingletonSlot).Bytes()),
+		Owners:          owners,
+		Threshold:       hexutil.Uint64(threshold.Uint64()),
+		Nonce:           (*hexutil.Big)(statedb.GetState(safe, safeNonceSlot).Big()),
+		FallbackHandler: common.BytesToAddress(statedb.GetState(safe, safeFallbackHandlerSlot).Bytes()),
+		Guard:           common.BytesToAddress(statedb.GetState(safe, safeGuardSlot).Bytes()),
+		Modules:         modules,
+	}, statedb.Error()
+}
+
+// SafeNonceConflict is a set of pending transactions executing Safe transactions
+// that are all valid for the Safe's current nonce. Only one of them can succeed,
+// the winner is the one the miner includes first (ordered by price and nonce).
+// The remaining transactions will fail once the winner is included.
+type SafeNonceConflict struct {
+	Safe        common.Address `json:"safe"`
+	Nonce       *hexutil.Big   `json:"nonce"`
+	Winner      common.Hash    `json:"winner"`
+	Invalidated []common.Hash  `json:"invalidated"`
+}
+
+// contains returns whether the given transaction is part of the conflict.
+func (c *SafeNonceConflict) contains(hash common.Hash) bool {
+	if c.Winner == hash {
+		return true
+	}
+	for _, invalidated := range c.Invalidated {
+		if invalidated == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeExecution returns whether the transaction calls execTransaction.
+func isSafeExecution(tx *types.Transaction) bool {
+	return tx.To() != nil && bytes.HasPrefix(tx.Data(), execTransactionSelector)
+}
+
+// pendingSafeExecutions returns the pending transactions calling execTransaction,
+// grouped by the Safe they target.
+func (s *PublicGnosisAPI) pendingSafeExecutions() (map[common.Address]types.Transactions, error) {
+	pending, err := s.b.GetPoolTransactions()
+	if err != nil {
+		return nil, err
+	}
+	executions := make(map[common.Address]types.Transactions)
+	for _, tx := range pending {
+		if isSafeExecution(tx) {
+			executions[*tx.To()] = append(executions[*tx.To()], tx)
+		}
+	}
+	return executions, nil
+}
+
+// nonceConflict simulates the pending executions of a Safe on top of the given
+// state. At most maxSafeExecutions of them are simulated, picked in the order the
+// miner would include them. The executions that succeed are valid for the Safe's
+// current nonce and conflict with each other. Executions that revert are either
+// signed for a later nonce or invalid, and don't take part in the conflict. If
+// fewer than two executions succeed, nil is returned.
+func (s *PublicGnosisAPI) nonceConflict(ctx context.Context, statedb *state.StateDB, header *types.Header, safe common.Address, txs types.Transactions) (*SafeNonceConflict, error) {
+	if len(txs) < 2 {
+		return nil, nil
+	}
+	signer := types.MakeSigner(s.b.ChainConfig(), header.Number)
+
+	// Group the executions by sender in nonce order, as the miner expects them
+	pending := make(map[common.Address]types.Transactions)
+	for _, tx := range txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		pending[from] = append(pending[from], tx)
+	}
+	for _, list := range pending {
+		sort.Sort(types.TxByNonce(list))
+	}
+	var (
+		candidates = types.NewTransactionsByPriceAndNonce(signer, pending)
+		valid      = make(map[common.Address]types.Transactions)
+		counter    int
+	)
+	for simulated := 0; simulated < maxSafeExecutions; simulated++ {
+		tx := candidates.Peek()
+		if tx == nil {
+			break
+		}
+		candidates.Shift()
+
+		from, _ := types.Sender(signer, tx) // already cached above
+		msg := types.NewMessage(from, tx.To(), 0, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data(), false)
+		evm, vmError, err := s.b.GetEVM(ctx, msg, statedb.Copy(), header, vm.Config{})
+		if err != nil {
+			return nil, err
+		}
+		// Abort the simulation once the check times out
+		go func() {
+			<-ctx.Done()
+			evm.Cancel()
+		}()
+		_, _, failed, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errCallTimeout
+		}
+		if err := vmError(); err != nil {
+			return nil, err
+		}
+		if err == nil && !failed {
+			valid[from] = append(valid[from], tx)
+			counter++
+		}
+	}
+	if counter < 2 {
+		return nil, nil
+	}
+	for _, list := range valid {
+		sort.Sort(types.TxByNonce(list))
+	}
+	// Order the conflicting transactions the same way the miner does
+	conflict := &SafeNonceConflict{
+		Safe:  safe,
+		Nonce: (*hexutil.Big)(statedb.GetState(safe, safeNonceSlot).Big()),
+	}
+	ordered := types.NewTransactionsByPriceAndNonce(signer, valid)
+	conflict.Winner = ordered.Peek().Hash()
+	for ordered.Shift(); ordered.Peek() != nil; ordered.Shift() {
+		conflict.Invalidated = append(conflict.Invalidated, ordered.Peek().Hash())
+	}
+	return conflict, nil
+}
+
+// nonceConflicts returns the nonce conflicts between the pending executions of
+// the given Safes, or of all Safes if none are given. All simulations share a
+// single timeout.
+func (s *PublicGnosisAPI) nonceConflicts(ctx context.Context, safes map[common.Address]bool) ([]*SafeNonceConflict, error) {
+	ctx, cancel := context.WithTimeout(ctx, nonceConflictTimeout)
+	defer cancel()
+
+	executions, err := s.pendingSafeExecutions()
+	if err != nil {
+		return nil, err
+	}
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	conflicts := []*SafeNonceConflict{}
+	for safe, txs := range executions {
+		if safes != nil && !safes[safe] {
+			continue
+		}
+		conflict, err := s.nonceConflict(ctx, statedb, header, safe, txs)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return bytes.Compare(conflicts[i].Safe[:], conflicts[j].Safe[:]) < 0
+	})
+	return conflicts, nil
+}
+
+// GetNonceConflicts returns all Safes for which multiple pending transactions
+// execute a Safe transaction with the current nonce, flagging which of them is
+// expected to be mined and which will be invalidated by it.
+func (s *PublicGnosisAPI) GetNonceConflicts(ctx context.Context) ([]*SafeNonceConflict, error) {
+	return s.nonceConflicts(ctx, nil)
+}
+
+// NonceConflicts creates a subscription that is triggered each time a transaction
+// entering the transaction pool takes part in a Safe nonce conflict.
+func (s *PublicGnosisAPI) NonceConflicts(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		txs := make(chan core.NewTxsEvent, txChanSize)
+		txsSub := s.b.SubscribeNewTxsEvent(txs)
+		defer txsSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-txs:
+				// Only recheck the Safes targeted by new executions
+				safes := make(map[common.Address]bool)
+				for _, tx := range ev.Txs {
+					if isSafeExecution(tx) {
+						safes[*tx.To()] = true
+					}
+				}
+				if len(safes) == 0 {
+					continue
+				}
+				conflicts, err := s.nonceConflicts(context.Background(), safes)
+				if err != nil {
+					log.Warn("Failed to check Safe nonce conflicts", "err", err)
+					continue
+				}
+				for _, conflict := range conflicts {
+					for _, tx := range ev.Txs {
+						if conflict.contains(tx.Hash()) {
+							notifier.Notify(rpcSub.ID, conflict)
+							break
+						}
+					}
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// SafeModule describes a module enabled on a Safe. Kind is set to "allowance",
+// "recovery" or "4337" if the module was identified as one of these.
+type SafeModule struct {
+	Address common.Address `json:"address"`
+	HasCode bool           `json:"hasCode"`
+	Kind    string         `json:"kind,omitempty"`
+}
+
+// SafeModulesPage is a page of the modules enabled on a Safe. Next is the last
+// returned module to continue from, or the sentinel address if the page ends
+// with the last module.
+type SafeModulesPage struct {
+	Modules []*SafeModule  `json:"modules"`
+	Next    common.Address `json:"next"`
+}
+
+// moduleKind identifies well known modules by their exposed interface. Modules
+// failing the identifying calls are of no known kind, only running out of time
+// for the calls is reported as an error.
+func moduleKind(caller *contractCaller, module common.Address) (string, error) {
+	ret, err := caller.call(module, moduleNameSelector)
+	if err == errCallTimeout {
+		return "", err
+	}
+	if err == nil {
+		if name, err := decodeABIBytes(ret); err == nil {
+			switch string(name) {
+			case allowanceModuleName:
+				return "allowance", nil
+			case socialRecoveryModuleName:
+				return "recovery", nil
+			}
+		}
+	}
+	ret, err = caller.call(module, moduleEntryPointSelector)
+	if err == errCallTimeout {
+		return "", err
+	}
+	if err == nil && len(ret) == 32 {
+		// Reject catch-all fallbacks by requiring an entry point contract
+		entryPoint := common.BytesToAddress(ret)
+		if new(big.Int).SetBytes(ret).BitLen() <= 160 && caller.evm.StateDB.GetCodeSize(entryPoint) > 0 {
+			return "4337", nil
+		}
+	}
+	return "", nil
+}
+
+// GetModules returns up to pageSize modules enabled on the given Safe, starting
+// after the given entry (the sentinel or zero address to start from the first
+// module). Like getModulesPaginated, the list is walked in the Safe's storage,
+// which is identical for all Safe versions. As getModulesPaginated does since
+// Safe 1.4.0, a start entry that is not an enabled module is rejected.
+func (s *PublicGnosisAPI) GetModules(ctx context.Context, safe common.Address, start common.Address, pageSize hexutil.Uint, blockNr rpc.BlockNumber) (*SafeModulesPage, error) {
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	if statedb.GetState(safe, safeThresholdSlot).Big().Sign() == 0 {
+		if err := statedb.Error(); err != nil {
+			return nil, err
+		}
+		return nil, errNotSafe
+	}
+	if start == (common.Address{}) {
+		start = safeSentinel
+	}
+	if start != safeSentinel && statedb.GetState(safe, safeMappingSlot(safeModulesSlot, start)) == (common.Hash{}) {
+		if err := statedb.Error(); err != nil {
+			return nil, err
+		}
+		return nil, errInvalidModuleStart
+	}
+	limit := int(pageSize)
+	if limit == 0 || limit > maxSafeListLength {
+		limit = maxSafeListLength
+	}
+	modules, next := readSafeList(statedb, safe, safeModulesSlot, start, limit)
+
+	caller, err := newContractCaller(ctx, s.b, statedb, header)
+	if err != nil {
+		return nil, err
+	}
+	defer caller.close()
+
+	page := &SafeModulesPage{Modules: make([]*SafeModule, len(modules)), Next: next}
+	for i, module := range modules {
+		page.Modules[i] = &SafeModule{
+			Address: module,
+			HasCode: statedb.GetCodeSize(module) > 0,
+		}
+		if page.Modules[i].HasCode {
+			if page.Modules[i].Kind, err = moduleKind(caller, module); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return page, statedb.Error()
+}
+
+// BalancePoint is the balance of an account at a given block.
+type BalancePoint struct {
+	Number    hexutil.Uint64 `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Timestamp hexutil.Uint64 `json:"timestamp"`
+	Balance   *hexutil.Big   `json:"balance"`
+}
+
+// balanceCacheKey identifies a cached ether (zero token) or token balance.
+type balanceCacheKey struct {
+	block  common.Hash
+	holder common.Address
+	token  common.Address
+}
+
+// balanceAt returns the ether balance, or the token balance if a token is given,
+// of the holder at the given block.
+func (s *PublicGnosisAPI) balanceAt(ctx context.Context, number uint64, holder common.Address, token *common.Address) (*BalancePoint, error) {
+	header, err := s.b.HeaderByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	key := balanceCacheKey{block: header.Hash(), holder: holder}
+	if token != nil {
+		key.token = *token
+	}
+	if balance, ok := s.balanceCache.Get(key); ok {
+		return &BalancePoint{
+			Number:    hexutil.Uint64(number),
+			Hash:      key.block,
+			Timestamp: hexutil.Uint64(header.Time.Uint64()),
+			Balance:   (*hexutil.Big)(balance.(*big.Int)),
+		}, nil
+	}
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, rpc.BlockNumber(number))
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	balance := statedb.GetBalance(holder)
+	if token != nil {
+		caller, err := newContractCaller(ctx, s.b, statedb, header)
+		if err != nil {
+			return nil, err
+		}
+		balance, err = caller.tokenBalance(*token, holder)
+		caller.close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := statedb.Error(); err != nil {
+		return nil, err
+	}
+	key.block = header.Hash()
+	s.balanceCache.Add(key, balance)
+
+	return &BalancePoint{
+		Number:    hexutil.Uint64(number),
+		Hash:      key.block,
+		Timestamp: hexutil.Uint64(header.Time.Uint64()),
+		Balance:   (*hexutil.Big)(balance),
+	}, nil
+}
+
+// GetBalanceHistory returns the ether balance, or the token balance if a token is
+// given, of the Safe at every step-th block in the given range. The historical
+// states must be available, i.e. the node has to run in archive mode for ranges
+// older than the recent in-memory states.
+func (s *PublicGnosisAPI) GetBalanceHistory(ctx context.Context, safe common.Address, token *common.Address, fromBlock, toBlock, step hexutil.Uint64) ([]*BalancePoint, error) {
+	if step == 0 {
+		step = 1
+	}
+	if toBlock < fromBlock || uint64(toBlock) > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid block range %d-%d", fromBlock, toBlock)
+	}
+	if uint64(toBlock-fromBlock)/uint64(step) >= maxBalanceHistoryPoints {
+		return nil, errTooManyBalancePoints
+	}
+	var points []*BalancePoint
+	for number := uint64(fromBlock); ; number += uint64(step) {
+		point, err := s.balanceAt(ctx, number, safe, token)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+
+		// Stop before the next step would overshoot the range (or overflow)
+		if uint64(toBlock)-number < uint64(step) {
+			break
+		}
+	}
+	return points, nil
+}
+
+// predictSafeAddress computes the address of the proxy deployed by the factory's
+// createProxyWithNonce for the given singleton, initializer and salt nonce. The
+// proxy creation code is read from the factory, so the prediction follows the
+// proxy version the factory actually deploys.
+func predictSafeAddress(caller *contractCaller, factory, singleton common.Address, initializer []byte, saltNonce *big.Int) (common.Address, error) {
+	ret, err := caller.call(factory, proxyCreationCodeSelector)
+	if err != nil {
+		return common.Address{}, err
+	}
+	creationCode, err := decodeABIBytes(ret)
+	if err != nil {
+		return common.Address{}, err
+	}
+	// salt = keccak256(keccak256(initializer) ++ saltNonce)
+	salt := crypto.Keccak256Hash(crypto.Keccak256(initializer), common.BigToHash(saltNonce).Bytes())
+
+	// initcode = creationCode ++ uint256(singleton)
+	initCode := append(common.CopyBytes(creationCode), common.LeftPadBytes(singleton.Bytes(), 32)...)
+
+	return crypto.CreateAddress2(factory, salt, crypto.Keccak256(initCode)), nil
+}
+
+// SafeAddressPrediction is the counterfactual address of a Safe proxy and
+// whether a contract is already deployed at it. If the deployment was verified,
+// Verified reports whether a simulated deployment created the proxy at the
+// predicted address, and Error why it failed.
+type SafeAddressPrediction struct {
+	Address  common.Address `json:"address"`
+	Deployed bool           `json:"deployed"`
+	Verified *bool          `json:"verified,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// PredictSafeAddress returns the address at which the given proxy factory will
+// deploy a Safe for the given singleton, initializer and salt nonce using
+// createProxyWithNonce, and whether that address is already deployed.
+func (s *PublicGnosisAPI) PredictSafeAddress(ctx context.Context, factory, singleton common.Address, initializer hexutil.Bytes, saltNonce *hexutil.Big, blockNr rpc.BlockNumber) (*SafeAddressPrediction, error) {
+	return safeAddressPrediction(ctx, s.b, factory, singleton, initializer, saltNonce, blockNr, false)
+}
+
+// safeAddressPrediction predicts the address of a Safe proxy on the state of
+// the given block. If verify is set, the deployment is also simulated through
+// the factory and the created proxy compared to the prediction. A missing salt
+// nonce defaults to zero.
+func safeAddressPrediction(ctx context.Context, b Backend, factory, singleton common.Address, initializer []byte, saltNonce *hexutil.Big, blockNr rpc.BlockNumber, verify bool) (*SafeAddressPrediction, error) {
+	statedb, header, err := b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	caller, err := newContractCaller(ctx, b, statedb, header)
+	if err != nil {
+		return nil, err
+	}
+	defer caller.close()
+
+	nonce := new(big.Int)
+	if saltNonce != nil {
+		nonce = saltNonce.ToInt()
+	}
+	address, err := predictSafeAddress(caller, factory, singleton, initializer, nonce)
+	if err != nil {
+		return nil, err
+	}
+	result := &SafeAddressPrediction{
+		Address:  address,
+		Deployed: statedb.GetCodeSize(address) > 0,
+	}
+	if !verify {
+		return result, statedb.Error()
+	}
+	// Simulate the deployment and compare the created proxy
+	input := append(common.CopyBytes(createProxyWithNonceSelector), common.LeftPadBytes(singleton.Bytes(), 32)...)
+	input = append(input, math.PaddedBigBytes(big.NewInt(96), 32)...)
+	input = append(input, math.PaddedBigBytes(nonce, 32)...)
+	input = append(input, math.PaddedBigBytes(big.NewInt(int64(len(initializer))), 32)...)
+	input = append(input, common.RightPadBytes(initializer, (len(initializer)+31)/32*32)...)
+
+	verified := false
+	result.Verified = &verified
+
+	ret, err := caller.execute(factory, input)
+	switch {
+	case err == errCallTimeout:
+		return nil, err
+	case err != nil:
+		if reason, ok := revertReason(ret); ok {
+			result.Error = reason
+		} else {
+			result.Error = err.Error()
+		}
+	case len(ret) < 32:
+		result.Error = errInvalidReturnData.Error()
+	default:
+		verified = common.BytesToAddress(ret[:32]) == address
+	}
+	return result, statedb.Error()
+}
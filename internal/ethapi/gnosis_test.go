@@ -0,0 +1,663 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// abiWord encodes the given value as a single 32 byte ABI word.
+func abiWord(x *big.Int) []byte {
+	return math.PaddedBigBytes(x, 32)
+}
+
+// abiEncode concatenates the given ABI words and data.
+func abiEncode(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestDecodeABIBytes(t *testing.T) {
+	var (
+		data    = common.RightPadBytes([]byte("abc"), 32)
+		maxU64  = new(big.Int).SetUint64(^uint64(0))
+		maxU256 = math.MaxBig256
+	)
+	tests := []struct {
+		input []byte
+		want  []byte
+		fail  bool
+	}{
+		// Well formed encodings
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(big.NewInt(3)), data), want: []byte("abc")},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(big.NewInt(0))), want: []byte{}},
+		{input: abiEncode(abiWord(big.NewInt(64)), abiWord(big.NewInt(0)), abiWord(big.NewInt(1)), data), want: []byte("a")},
+
+		// Truncated return data
+		{input: nil, fail: true},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(big.NewInt(0)))[:63], fail: true},
+
+		// Offsets pointing outside of the return data
+		{input: abiEncode(abiWord(big.NewInt(65)), abiWord(big.NewInt(0)), data), fail: true},
+		{input: abiEncode(abiWord(new(big.Int).Sub(maxU64, big.NewInt(31))), abiWord(big.NewInt(0))), fail: true},
+		{input: abiEncode(abiWord(maxU64), abiWord(big.NewInt(0))), fail: true},
+		{input: abiEncode(abiWord(new(big.Int).Add(maxU64, big.NewInt(1))), abiWord(big.NewInt(0))), fail: true},
+		{input: abiEncode(abiWord(maxU256), abiWord(big.NewInt(0))), fail: true},
+
+		// Lengths exceeding the return data
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(big.NewInt(33)), data), fail: true},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(new(big.Int).Sub(maxU64, big.NewInt(63))), data), fail: true},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(maxU64), data), fail: true},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(new(big.Int).Add(maxU64, big.NewInt(1))), data), fail: true},
+		{input: abiEncode(abiWord(big.NewInt(32)), abiWord(maxU256), data), fail: true},
+	}
+	for i, tt := range tests {
+		have, err := decodeABIBytes(tt.input)
+		if tt.fail {
+			if err != errInvalidDynamicReturnData {
+				t.Errorf("test %d: error mismatch: have %v, want %v", i, err, errInvalidDynamicReturnData)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to decode: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: output mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
+
+// testProxyFactoryCode is the runtime code of a minimal proxy factory following
+// the Safe proxy factory's salt and init code rules:
+//
+//	proxyCreationCode() returns (bytes):
+//	    return 0x60016000f3 // deploys a proxy with a single STOP as its code
+//
+//	createProxyWithNonce(address singleton, bytes initializer, uint256 saltNonce):
+//	    salt = keccak256(keccak256(initializer) ++ saltNonce)
+//	    return create2(0, proxyCreationCode() ++ uint256(singleton), salt)
+//
+// Any call with 4 bytes of input is answered as proxyCreationCode, all others
+// as createProxyWithNonce with the initializer at the canonical ABI offset.
+var testProxyFactoryCode = common.FromHex(
+	"36600414605a57" + // if calldatasize == 4: jump to proxyCreationCode
+		"60643580608460003760002060005260443560205260406000207f" + // salt
+		"60016000f3000000000000000000000000000000000000000000000000000000" +
+		"600052600435600552602560006000f5" + // create2(creationCode ++ singleton)
+		"60005260206000f3" + // return address
+		"5b602060005260056020527f" + // proxyCreationCode
+		"60016000f3000000000000000000000000000000000000000000000000000000" +
+		"60405260606000f3",
+)
+
+func TestPredictSafeAddress(t *testing.T) {
+	var (
+		factory   = common.HexToAddress("0x76E2cFc1F5Fa8F6a5b3fC4c8F4788F0116861F9B")
+		singleton = common.HexToAddress("0x34CfAC646f301356fAa8B21e94227e3583Fe3F5F")
+	)
+	b := newTestBackend(t, core.GenesisAlloc{factory: {Code: testProxyFactoryCode}})
+
+	// The expected addresses were produced by deploying the proxies through the
+	// factory above, they are rechecked against an actual deployment below.
+	tests := []struct {
+		initializer []byte
+		saltNonce   *big.Int
+		want        common.Address
+	}{
+		{nil, big.NewInt(0), common.HexToAddress("0x53f51ea903902fb0ddbaaab45028ac1502b2c980")},
+		{[]byte{1, 2, 3}, big.NewInt(42), common.HexToAddress("0x7703e77385457ac7ed09e8dbfeeaae890a839443")},
+		{bytes.Repeat([]byte{0xff}, 100), math.MaxBig256, common.HexToAddress("0xdf441cf576183641d03d8da1b5eebe666520fff1")},
+	}
+	for i, tt := range tests {
+		statedb, header, _ := b.StateAndHeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+		caller, err := newContractCaller(context.Background(), b, statedb, header)
+		if err != nil {
+			t.Fatalf("test %d: failed to create caller: %v", i, err)
+		}
+		have, err := predictSafeAddress(caller, factory, singleton, tt.initializer, tt.saltNonce)
+		if err != nil {
+			t.Fatalf("test %d: failed to predict address: %v", i, err)
+		}
+		if have != tt.want {
+			t.Errorf("test %d: address mismatch: have %x, want %x", i, have, tt.want)
+		}
+		// Deploy the proxy and ensure it ends up at the predicted address
+		input := abiEncode(
+			createProxyWithNonceSelector,
+			common.LeftPadBytes(singleton.Bytes(), 32),
+			abiWord(big.NewInt(96)),
+			abiWord(tt.saltNonce),
+			abiWord(big.NewInt(int64(len(tt.initializer)))),
+			common.RightPadBytes(tt.initializer, (len(tt.initializer)+31)/32*32),
+		)
+		ret, err := caller.execute(factory, input)
+		if err != nil {
+			t.Fatalf("test %d: failed to deploy proxy: %v", i, err)
+		}
+		if deployed := common.BytesToAddress(ret); deployed != have {
+			t.Errorf("test %d: deployment mismatch: have %x, deployed %x", i, have, deployed)
+		}
+		if caller.evm.StateDB.GetCodeSize(have) == 0 {
+			t.Errorf("test %d: no proxy deployed at %x", i, have)
+		}
+		caller.close()
+	}
+}
+
+func TestComputeProxyAddressVerify(t *testing.T) {
+	var (
+		factory   = common.HexToAddress("0x76E2cFc1F5Fa8F6a5b3fC4c8F4788F0116861F9B")
+		singleton = common.HexToAddress("0x34CfAC646f301356fAa8B21e94227e3583Fe3F5F")
+		verify    = true
+	)
+	b := newTestBackend(t, core.GenesisAlloc{factory: {Code: testProxyFactoryCode}})
+
+	// Both APIs must agree on the prediction, the relay one also verifying it
+	predicted, err := NewPublicGnosisAPI(b).PredictSafeAddress(context.Background(), factory, singleton, []byte{1, 2, 3}, (*hexutil.Big)(big.NewInt(42)), rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("failed to predict address: %v", err)
+	}
+	if predicted.Verified != nil {
+		t.Errorf("unrequested verification reported: %v", *predicted.Verified)
+	}
+	computed, err := NewPrivateRelayAPI(b).ComputeProxyAddress(context.Background(), factory, singleton, []byte{1, 2, 3}, (*hexutil.Big)(big.NewInt(42)), rpc.LatestBlockNumber, &verify)
+	if err != nil {
+		t.Fatalf("failed to compute address: %v", err)
+	}
+	if computed.Address != predicted.Address {
+		t.Errorf("address mismatch: computed %x, predicted %x", computed.Address, predicted.Address)
+	}
+	if computed.Verified == nil || !*computed.Verified {
+		t.Errorf("deployment not verified: %q", computed.Error)
+	}
+	if computed.Deployed {
+		t.Errorf("simulated deployment leaked into the chain state")
+	}
+}
+
+// testSafeCode is a stand-in for the execTransaction of a Safe. In place of
+// checking signatures over the Safe nonce, it accepts executions whose second
+// argument matches the nonce in storage slot 5 and reverts all others.
+var testSafeCode = common.FromHex("60243560055414600e57600080fd5b00")
+
+// testSafeExecution signs a pool transaction calling execTransaction on the
+// given Safe for the given Safe nonce.
+func testSafeExecution(t *testing.T, b *testBackend, key *ecdsa.PrivateKey, nonce uint64, price int64, safe common.Address, safeNonce int64) *types.Transaction {
+	data := abiEncode(execTransactionSelector, abiWord(big.NewInt(0)), abiWord(big.NewInt(safeNonce)))
+
+	tx, err := types.SignTx(types.NewTransaction(nonce, safe, new(big.Int), 100000, big.NewInt(price), data), types.MakeSigner(b.config, b.header.Number), key)
+	if err != nil {
+		t.Fatalf("failed to sign execution: %v", err)
+	}
+	return tx
+}
+
+// testKeys generates the given number of private keys.
+func testKeys(t *testing.T, n int) []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// checkNonceConflict checks that the conflict was found for the given Safe and
+// nonce, with its transactions in the given order.
+func checkNonceConflict(t *testing.T, conflict *SafeNonceConflict, safe common.Address, nonce int64, txs ...*types.Transaction) {
+	t.Helper()
+
+	if conflict.Safe != safe {
+		t.Errorf("safe mismatch: have %x, want %x", conflict.Safe, safe)
+	}
+	if conflict.Nonce.ToInt().Int64() != nonce {
+		t.Errorf("nonce mismatch: have %v, want %d", conflict.Nonce, nonce)
+	}
+	if conflict.Winner != txs[0].Hash() {
+		t.Errorf("winner mismatch: have %x, want %x", conflict.Winner, txs[0].Hash())
+	}
+	if len(conflict.Invalidated) != len(txs)-1 {
+		t.Fatalf("invalidated count mismatch: have %d, want %d", len(conflict.Invalidated), len(txs)-1)
+	}
+	for i, tx := range txs[1:] {
+		if conflict.Invalidated[i] != tx.Hash() {
+			t.Errorf("invalidated %d mismatch: have %x, want %x", i, conflict.Invalidated[i], tx.Hash())
+		}
+	}
+}
+
+func TestNonceConflictOrdering(t *testing.T) {
+	safe := common.HexToAddress("0x5afe")
+	b := newTestBackend(t, core.GenesisAlloc{
+		safe: {Code: testSafeCode, Storage: map[common.Hash]common.Hash{safeNonceSlot: common.BigToHash(big.NewInt(3))}},
+	})
+	keys := testKeys(t, 2)
+
+	// The cheap first execution of a sender must be mined before its pricier
+	// second one, regardless of the order of the pool
+	var (
+		cheap   = testSafeExecution(t, b, keys[0], 0, 1, safe, 3)
+		pricey  = testSafeExecution(t, b, keys[0], 1, 10, safe, 3)
+		between = testSafeExecution(t, b, keys[1], 0, 5, safe, 3)
+	)
+	b.pool = types.Transactions{pricey, between, cheap}
+
+	conflicts, err := NewPublicGnosisAPI(b).GetNonceConflicts(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("conflict count mismatch: have %d, want 1", len(conflicts))
+	}
+	checkNonceConflict(t, conflicts[0], safe, 3, between, cheap, pricey)
+}
+
+func TestNonceConflictLimit(t *testing.T) {
+	safe := common.HexToAddress("0x5afe")
+	b := newTestBackend(t, core.GenesisAlloc{
+		safe: {Code: testSafeCode},
+	})
+	keys := testKeys(t, 2)
+
+	// A single sender queueing more executions than are simulated, each paying
+	// more than the one before and all outbidding a second sender
+	var executions types.Transactions
+	for i := 0; i < maxSafeExecutions+2; i++ {
+		executions = append(executions, testSafeExecution(t, b, keys[0], uint64(i), int64(100+i), safe, 0))
+	}
+	outbid := testSafeExecution(t, b, keys[1], 0, 50, safe, 0)
+
+	b.pool = append(types.Transactions{outbid}, executions...)
+	for i, j := 1, len(b.pool)-1; i < j; i, j = i+1, j-1 {
+		b.pool[i], b.pool[j] = b.pool[j], b.pool[i]
+	}
+	conflicts, err := NewPublicGnosisAPI(b).GetNonceConflicts(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("conflict count mismatch: have %d, want 1", len(conflicts))
+	}
+	// Only the executions the miner includes first are simulated
+	checkNonceConflict(t, conflicts[0], safe, 0, executions[:maxSafeExecutions]...)
+}
+
+func TestNonceConflictReverts(t *testing.T) {
+	var (
+		single   = common.HexToAddress("0x5afe01")
+		multiple = common.HexToAddress("0x5afe02")
+		other    = common.HexToAddress("0xe0a")
+	)
+	b := newTestBackend(t, core.GenesisAlloc{
+		single:   {Code: testSafeCode},
+		multiple: {Code: testSafeCode},
+	})
+	keys := testKeys(t, 4)
+
+	var (
+		// A single valid execution next to executions of later Safe nonces
+		lone = testSafeExecution(t, b, keys[0], 0, 1, single, 0)
+		late = testSafeExecution(t, b, keys[1], 0, 50, single, 1)
+
+		// A conflict with a reverting execution outbidding both valid ones
+		first   = testSafeExecution(t, b, keys[2], 0, 20, multiple, 0)
+		second  = testSafeExecution(t, b, keys[3], 0, 10, multiple, 0)
+		reverts = testSafeExecution(t, b, keys[1], 1, 100, multiple, 7)
+	)
+	// Calls to anything but execTransaction are no Safe executions
+	transfer, _ := types.SignTx(types.NewTransaction(1, other, big.NewInt(1), 21000, big.NewInt(1000), execTransactionSelector[:3]), types.MakeSigner(b.config, b.header.Number), keys[0])
+
+	b.pool = types.Transactions{lone, late, first, second, reverts, transfer}
+
+	conflicts, err := NewPublicGnosisAPI(b).GetNonceConflicts(context.Background())
+	if err != nil {
+		t.Fatalf("failed to retrieve conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("conflict count mismatch: have %d, want 1", len(conflicts))
+	}
+	checkNonceConflict(t, conflicts[0], multiple, 0, first, second)
+}
+
+func TestNonceConflictsSubscription(t *testing.T) {
+	var (
+		notified  = common.HexToAddress("0x5afe01")
+		unrelated = common.HexToAddress("0x5afe02")
+		single    = common.HexToAddress("0x5afe03")
+	)
+	b := newTestBackend(t, core.GenesisAlloc{
+		notified:  {Code: testSafeCode},
+		unrelated: {Code: testSafeCode},
+		single:    {Code: testSafeCode},
+	})
+	keys := testKeys(t, 5)
+
+	var (
+		winner = testSafeExecution(t, b, keys[0], 0, 20, notified, 0)
+		loser  = testSafeExecution(t, b, keys[1], 0, 10, notified, 0)
+		lone   = testSafeExecution(t, b, keys[2], 0, 10, single, 0)
+	)
+	b.pool = types.Transactions{
+		winner, loser, lone,
+		testSafeExecution(t, b, keys[3], 0, 10, unrelated, 0),
+		testSafeExecution(t, b, keys[4], 0, 10, unrelated, 0),
+	}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("gnosis", NewPublicGnosisAPI(b)); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	conflicts := make(chan *SafeNonceConflict)
+	sub, err := client.Subscribe(context.Background(), "gnosis", conflicts, "nonceConflicts")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// Wait for the subscription to listen to the pool before feeding it
+	transfer, _ := types.SignTx(types.NewTransaction(1, unrelated, big.NewInt(1), 21000, big.NewInt(1), nil), types.MakeSigner(b.config, b.header.Number), keys[0])
+	for b.txFeed.Send(core.NewTxsEvent{Txs: types.Transactions{transfer}}) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Neither a Safe without conflict nor the conflicts of Safes not targeted by
+	// new transactions are notified
+	b.txFeed.Send(core.NewTxsEvent{Txs: types.Transactions{lone}})
+	b.txFeed.Send(core.NewTxsEvent{Txs: types.Transactions{loser}})
+
+	select {
+	case conflict := <-conflicts:
+		checkNonceConflict(t, conflict, notified, 0, winner, loser)
+	case err := <-sub.Err():
+		t.Fatalf("subscription failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("conflict not notified")
+	}
+	select {
+	case conflict := <-conflicts:
+		t.Fatalf("unexpected conflict notified for %x", conflict.Safe)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// abiString encodes the given string as ABI return data.
+func abiString(s string) []byte {
+	return abiEncode(abiWord(big.NewInt(32)), abiWord(big.NewInt(int64(len(s)))), common.RightPadBytes([]byte(s), (len(s)+31)/32*32))
+}
+
+// testReturn is the fixed return data of a test contract for a function selector.
+type testReturn struct {
+	selector []byte
+	data     []byte
+	revert   bool // whether the data is returned by reverting
+}
+
+// testReturnCode assembles contract code answering each of the given function
+// selectors with its fixed return data. Any other call is reverted.
+func testReturnCode(returns ...testReturn) []byte {
+	// Dispatch on the selector in the first four bytes of the call data
+	code := []byte{byte(vm.PUSH1), 0, byte(vm.CALLDATALOAD), byte(vm.PUSH1), 0xe0, byte(vm.SHR)}
+
+	answer := len(code) + 11*len(returns) + 4 // offset of the first answer
+	for i, ret := range returns {
+		code = append(code, byte(vm.DUP1), byte(vm.PUSH4))
+		code = append(code, ret.selector...)
+		code = append(code, byte(vm.EQ), byte(vm.PUSH2), byte((answer+16*i)>>8), byte(answer+16*i), byte(vm.JUMPI))
+	}
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT))
+
+	// Copy the return data appended to the code into memory and return it
+	data := answer + 16*len(returns)
+	for _, ret := range returns {
+		exit := vm.RETURN
+		if ret.revert {
+			exit = vm.REVERT
+		}
+		code = append(code, byte(vm.JUMPDEST),
+			byte(vm.PUSH2), byte(len(ret.data)>>8), byte(len(ret.data)),
+			byte(vm.PUSH2), byte(data>>8), byte(data),
+			byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+			byte(vm.PUSH2), byte(len(ret.data)>>8), byte(len(ret.data)),
+			byte(vm.PUSH1), 0, byte(exit),
+		)
+		data += len(ret.data)
+	}
+	for _, ret := range returns {
+		code = append(code, ret.data...)
+	}
+	return code
+}
+
+// testSafeList stores the given entries as a Safe linked list at the given slot.
+func testSafeList(storage map[common.Hash]common.Hash, slot common.Hash, entries ...common.Address) {
+	current := safeSentinel
+	for _, entry := range entries {
+		storage[safeMappingSlot(slot, current)] = common.BytesToHash(entry.Bytes())
+		current = entry
+	}
+	storage[safeMappingSlot(slot, current)] = common.BytesToHash(safeSentinel.Bytes())
+}
+
+func TestGetSafeInfo(t *testing.T) {
+	var (
+		safe      = common.HexToAddress("0x5afe")
+		notSafe   = common.HexToAddress("0x0ff")
+		singleton = common.HexToAddress("0x51")
+		handler   = common.HexToAddress("0xfa")
+		guard     = common.HexToAddress("0x6a")
+		owners    = []common.Address{common.HexToAddress("0x0e1"), common.HexToAddress("0x0e2")}
+		modules   = []common.Address{common.HexToAddress("0x0a")}
+	)
+	// Lay out the storage as the Safe contracts do, independent of the slot
+	// variables used by the API
+	storage := map[common.Hash]common.Hash{
+		common.BigToHash(big.NewInt(0)): common.BytesToHash(singleton.Bytes()),
+		common.BigToHash(big.NewInt(4)): common.BigToHash(big.NewInt(2)),
+		common.BigToHash(big.NewInt(5)): common.BigToHash(big.NewInt(7)),
+
+		crypto.Keccak256Hash([]byte("fallback_manager.handler.address")): common.BytesToHash(handler.Bytes()),
+		crypto.Keccak256Hash([]byte("guard_manager.guard.address")):      common.BytesToHash(guard.Bytes()),
+	}
+	testSafeList(storage, common.BigToHash(big.NewInt(1)), modules...)
+	testSafeList(storage, common.BigToHash(big.NewInt(2)), owners...)
+
+	api := NewPublicGnosisAPI(newTestBackend(t, core.GenesisAlloc{
+		safe:    {Code: testSafeCode, Storage: storage},
+		notSafe: {Code: testSafeCode},
+	}))
+	info, err := api.GetSafeInfo(context.Background(), safe, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("failed to retrieve Safe info: %v", err)
+	}
+	want := &SafeInfo{
+		Address:         safe,
+		Singleton:       singleton,
+		Owners:          owners,
+		Threshold:       2,
+		Nonce:           (*hexutil.Big)(big.NewInt(7)),
+		FallbackHandler: handler,
+		Guard:           guard,
+		Modules:         modules,
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("Safe info mismatch: have %s, want %s", spew.Sdump(info), spew.Sdump(want))
+	}
+	if _, err := api.GetSafeInfo(context.Background(), notSafe, rpc.LatestBlockNumber); err != errNotSafe {
+		t.Fatalf("uninitialized Safe error mismatch: have %v, want %v", err, errNotSafe)
+	}
+}
+
+func TestGetBalanceHistory(t *testing.T) {
+	safe := common.HexToAddress("0x5afe")
+	b := newTestChain(t, core.GenesisAlloc{safe: {Balance: big.NewInt(9)}}, 4)
+	api := NewPublicGnosisAPI(b)
+
+	tests := []struct {
+		from, to, step hexutil.Uint64
+		numbers        []uint64
+		err            string
+	}{
+		{from: 0, to: 3, step: 1, numbers: []uint64{0, 1, 2, 3}},
+		{from: 0, to: 3, step: 0, numbers: []uint64{0, 1, 2, 3}},
+		{from: 0, to: 3, step: 2, numbers: []uint64{0, 2}},
+		{from: 1, to: 1, step: 1, numbers: []uint64{1}},
+		// The next step would overflow instead of overshooting the range
+		{from: 2, to: 3, step: math.MaxUint64, numbers: []uint64{2}},
+		{from: 3, to: 2, step: 1, err: "invalid block range 3-2"},
+		{from: 0, to: math.MaxInt64 + 1, step: math.MaxUint64, err: fmt.Sprintf("invalid block range 0-%d", uint64(math.MaxInt64+1))},
+		{from: 0, to: maxBalanceHistoryPoints, step: 1, err: errTooManyBalancePoints.Error()},
+		{from: 2, to: 5, step: 1, err: "block #4 not found"},
+	}
+	for i, tt := range tests {
+		points, err := api.GetBalanceHistory(context.Background(), safe, nil, tt.from, tt.to, tt.step)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("test %d: error mismatch: have %v, want %s", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to retrieve balance history: %v", i, err)
+			continue
+		}
+		if len(points) != len(tt.numbers) {
+			t.Errorf("test %d: point count mismatch: have %d, want %d", i, len(points), len(tt.numbers))
+			continue
+		}
+		for j, point := range points {
+			header, _ := b.HeaderByNumber(context.Background(), rpc.BlockNumber(tt.numbers[j]))
+			if uint64(point.Number) != tt.numbers[j] || point.Hash != header.Hash() {
+				t.Errorf("test %d, point %d: block mismatch: have #%d %x, want #%d %x", i, j, point.Number, point.Hash, tt.numbers[j], header.Hash())
+			}
+			if point.Balance.ToInt().Int64() != 9 {
+				t.Errorf("test %d, point %d: balance mismatch: have %v, want 9", i, j, point.Balance)
+			}
+		}
+	}
+}
+
+func TestGetModules(t *testing.T) {
+	var (
+		safe       = common.HexToAddress("0x5afe")
+		notSafe    = common.HexToAddress("0x0ff")
+		allowance  = common.HexToAddress("0x0a")
+		codeless   = common.HexToAddress("0x0b")
+		account    = common.HexToAddress("0x0c")
+		unknown    = common.HexToAddress("0x0d")
+		entryPoint = common.HexToAddress("0xe4")
+	)
+	storage := map[common.Hash]common.Hash{safeThresholdSlot: common.BigToHash(big.NewInt(1))}
+	testSafeList(storage, safeModulesSlot, allowance, codeless, account, unknown)
+
+	api := NewPublicGnosisAPI(newTestBackend(t, core.GenesisAlloc{
+		safe:       {Code: testSafeCode, Storage: storage},
+		notSafe:    {Code: testSafeCode},
+		allowance:  {Code: testReturnCode(testReturn{selector: moduleNameSelector, data: abiString(allowanceModuleName)})},
+		account:    {Code: testReturnCode(testReturn{selector: moduleEntryPointSelector, data: common.LeftPadBytes(entryPoint.Bytes(), 32)})},
+		unknown:    {Code: testReturnCode(testReturn{selector: moduleNameSelector, data: abiString("Unknown Module")})},
+		entryPoint: {Code: []byte{byte(vm.STOP)}},
+	}))
+	modules := []*SafeModule{
+		{Address: allowance, HasCode: true, Kind: "allowance"},
+		{Address: codeless},
+		{Address: account, HasCode: true, Kind: "4337"},
+		{Address: unknown, HasCode: true},
+	}
+	tests := []struct {
+		safe     common.Address
+		start    common.Address
+		pageSize hexutil.Uint
+		modules  []*SafeModule
+		next     common.Address
+		err      error
+	}{
+		// Complete lists, including ones ending exactly at the page boundary
+		{safe: safe, modules: modules, next: safeSentinel},
+		{safe: safe, start: safeSentinel, pageSize: 4, modules: modules, next: safeSentinel},
+		{safe: safe, start: account, pageSize: 5, modules: modules[3:], next: safeSentinel},
+		{safe: safe, start: unknown, pageSize: 1, modules: modules[4:], next: safeSentinel},
+
+		// Paging through the list
+		{safe: safe, pageSize: 2, modules: modules[:2], next: codeless},
+		{safe: safe, start: codeless, pageSize: 2, modules: modules[2:], next: safeSentinel},
+		{safe: safe, start: allowance, pageSize: 1, modules: modules[1:2], next: codeless},
+
+		// Invalid queries
+		{safe: safe, start: notSafe, err: errInvalidModuleStart},
+		{safe: notSafe, err: errNotSafe},
+	}
+	for i, tt := range tests {
+		page, err := api.GetModules(context.Background(), tt.safe, tt.start, tt.pageSize, rpc.LatestBlockNumber)
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if page.Next != tt.next {
+			t.Errorf("test %d: next mismatch: have %x, want %x", i, page.Next, tt.next)
+		}
+		if !reflect.DeepEqual(page.Modules, tt.modules) {
+			t.Errorf("test %d: modules mismatch: have %s, want %s", i, spew.Sdump(page.Modules), spew.Sdump(tt.modules))
+		}
+	}
+}
+
+func TestGetModulesTimeout(t *testing.T) {
+	var (
+		safe   = common.HexToAddress("0x5afe")
+		module = common.HexToAddress("0x0a")
+	)
+	storage := map[common.Hash]common.Hash{safeThresholdSlot: common.BigToHash(big.NewInt(1))}
+	testSafeList(storage, safeModulesSlot, module)
+
+	api := NewPublicGnosisAPI(newTestBackend(t, core.GenesisAlloc{
+		safe:   {Code: testSafeCode, Storage: storage},
+		module: {Code: testReturnCode(testReturn{selector: moduleNameSelector, data: abiString(allowanceModuleName)})},
+	}))
+	// Modules left unidentified once the time ran out must fail the query
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	if _, err := api.GetModules(ctx, safe, common.Address{}, 0, rpc.LatestBlockNumber); err != errCallTimeout {
+		t.Fatalf("error mismatch: have %v, want %v", err, errCallTimeout)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	relayBalancesTimer      = metrics.NewRegisteredTimer("relay/getBalances", nil)
+	relayPermitTimer        = metrics.NewRegisteredTimer("relay/checkPermit", nil)
+	relayTokenBalanceTimer  = metrics.NewRegisteredTimer("relay/tokenBalanceAt", nil)
+	relayTokenBalancesTimer = metrics.NewRegisteredTimer("relay/tokenBalancesAt", nil)
+	relayProxyAddressTimer  = metrics.NewRegisteredTimer("relay/computeProxyAddress", nil)
+	relayCreateSessionTimer = metrics.NewRegisteredTimer("relay/createSession", nil)
+	relaySessionCallTimer   = metrics.NewRegisteredTimer("relay/sessionCall", nil)
+	relaySessionSnapTimer   = metrics.NewRegisteredTimer("relay/sessionSnapshot", nil)
+	relaySessionRevertTimer = metrics.NewRegisteredTimer("relay/sessionRevert", nil)
+	relayCloseSessionTimer  = metrics.NewRegisteredTimer("relay/closeSession", nil)
+	relaySessionsGauge      = metrics.NewRegisteredGauge("relay/sessions/active", nil)
+	relaySessionExecTimer   = metrics.NewRegisteredTimer("relay/sessions/execution", nil)
+	relaySessionGasHist     = metrics.NewRegisteredHistogram("relay/sessions/gas", nil, metrics.NewExpDecaySample(1028, 0.015))
+	relaySessionRevertMeter = metrics.NewRegisteredMeter("relay/sessions/reverts", nil)
+	relayTimeoutMeter       = metrics.NewRegisteredMeter("relay/timeouts", nil)
+)
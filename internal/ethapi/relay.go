@@ -0,0 +1,468 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// contractCallGas is the gas allowance of a single contract call
+	// issued on behalf of a relay query (e.g. an ERC-20 balanceOf).
+	contractCallGas = 1000000
+
+	// contractCallTimeout is the total time allowed for all contract calls
+	// issued while serving a single relay query.
+	contractCallTimeout = 5 * time.Second
+
+	// tokenMetadataCacheLimit is the number of token metadata entries kept in
+	// memory.
+	tokenMetadataCacheLimit = 1024
+)
+
+var (
+	balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	allowanceSelector = crypto.Keccak256([]byte("allowance(address,address)"))[:4]
+	nameSelector      = crypto.Keccak256([]byte("name()"))[:4]
+	symbolSelector    = crypto.Keccak256([]byte("symbol()"))[:4]
+	decimalsSelector  = crypto.Keccak256([]byte("decimals()"))[:4]
+	permitSelector    = crypto.Keccak256([]byte("permit(address,address,uint256,uint256,uint8,bytes32,bytes32)"))[:4]
+	revertSelector    = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+	createProxyWithNonceSelector = crypto.Keccak256([]byte("createProxyWithNonce(address,bytes,uint256)"))[:4]
+
+	errInvalidReturnData = errors.New("invalid return data")
+	errCallTimeout       = errors.New("execution aborted (timeout)")
+)
+
+// contractCaller executes contract calls against a private copy of a single
+// state snapshot, sharing one EVM instance between all calls. Calls issued via
+// call are read-only, while execute may modify the private state, which all
+// later calls observe. The snapshot itself is never modified.
+type contractCaller struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	state   *state.StateDB // private state copy the calls are executed on
+	evm     *vm.EVM
+	vmError func() error
+}
+
+// newContractCaller creates a contract caller operating on a private copy of the
+// given state, so state changes made through execute never reach the snapshot.
+func newContractCaller(ctx context.Context, b Backend, statedb *state.StateDB, header *types.Header) (*contractCaller, error) {
+	ctx, cancel := context.WithTimeout(ctx, contractCallTimeout)
+
+	statedb = statedb.Copy()
+
+	msg := types.NewMessage(common.Address{}, nil, 0, new(big.Int), math.MaxUint64/2, new(big.Int), nil, false)
+	evm, vmError, err := b.GetEVM(ctx, msg, statedb, header, vm.Config{})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Abort any running call once the context is done
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+	return &contractCaller{ctx: ctx, cancel: cancel, state: statedb, evm: evm, vmError: vmError}, nil
+}
+
+// call executes a read-only call to the given contract and returns its output.
+func (c *contractCaller) call(to common.Address, input []byte) ([]byte, error) {
+	ret, _, err := c.evm.StaticCall(vm.AccountRef(common.Address{}), to, input, contractCallGas)
+	if c.ctx.Err() == context.DeadlineExceeded {
+		return nil, errCallTimeout
+	}
+	if err := c.vmError(); err != nil {
+		return nil, err
+	}
+	// Database errors are recorded on the private copy, not the snapshot
+	if err := c.state.Error(); err != nil {
+		return nil, err
+	}
+	return ret, err
+}
+
+// execute runs a state modifying call to the given contract on the caller's
+// private state copy. All later calls observe the changes made by it.
+func (c *contractCaller) execute(to common.Address, input []byte) ([]byte, error) {
+	ret, _, err := c.evm.Call(vm.AccountRef(common.Address{}), to, input, contractCallGas, new(big.Int))
+	if c.ctx.Err() == context.DeadlineExceeded {
+		return nil, errCallTimeout
+	}
+	if err := c.vmError(); err != nil {
+		return nil, err
+	}
+	// Database errors are recorded on the private copy, not the snapshot
+	if err := c.state.Error(); err != nil {
+		return nil, err
+	}
+	return ret, err
+}
+
+// callUint256 executes a read-only call to the given contract and decodes its
+// output as a single uint256 value.
+func (c *contractCaller) callUint256(to common.Address, input []byte) (*big.Int, error) {
+	ret, err := c.call(to, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) < 32 {
+		return nil, errInvalidReturnData
+	}
+	return new(big.Int).SetBytes(ret[:32]), nil
+}
+
+// close releases the resources held by the caller.
+func (c *contractCaller) close() {
+	c.cancel()
+}
+
+// tokenBalance retrieves the ERC-20 token balance of the given holder.
+func (c *contractCaller) tokenBalance(token, holder common.Address) (*big.Int, error) {
+	input := append(common.CopyBytes(balanceOfSelector), common.LeftPadBytes(holder.Bytes(), 32)...)
+	return c.callUint256(token, input)
+}
+
+// tokenAllowance retrieves the ERC-20 allowance granted by owner to spender.
+func (c *contractCaller) tokenAllowance(token, owner, spender common.Address) (*big.Int, error) {
+	input := append(common.CopyBytes(allowanceSelector), common.LeftPadBytes(owner.Bytes(), 32)...)
+	input = append(input, common.LeftPadBytes(spender.Bytes(), 32)...)
+	return c.callUint256(token, input)
+}
+
+// tokenString retrieves an ERC-20 string property of the given token. Besides
+// the standard string encoding, the bytes32 encoding of early tokens is
+// accepted.
+func (c *contractCaller) tokenString(token common.Address, selector []byte) (string, error) {
+	ret, err := c.call(token, common.CopyBytes(selector))
+	if err != nil {
+		return "", err
+	}
+	if len(ret) == 32 {
+		return string(bytes.TrimRight(ret, "\x00")), nil
+	}
+	value, err := decodeABIBytes(ret)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// revertReason extracts the reason string from the output of a call reverted
+// with Error(string).
+func revertReason(ret []byte) (string, bool) {
+	if len(ret) < 4 || !bytes.Equal(ret[:4], revertSelector) {
+		return "", false
+	}
+	reason, err := decodeABIBytes(ret[4:])
+	if err != nil {
+		return "", false
+	}
+	return string(reason), true
+}
+
+// tokenMetadata contains the optional ERC-20 metadata of a token. Properties
+// the token doesn't implement are left empty.
+type tokenMetadata struct {
+	name     string
+	symbol   string
+	decimals *hexutil.Uint
+}
+
+// PrivateRelayAPI provides an API for transaction relay services to inspect the
+// state relevant for relaying transactions. Its simulation sessions are shared
+// by all clients, so the API is meant to be served to the relay service only,
+// never on a publicly reachable endpoint. It is therefore only exposed when the
+// relay namespace is explicitly enabled.
+type PrivateRelayAPI struct {
+	b             Backend
+	metadataCache *lru.Cache // Token metadata keyed by token address
+
+	sessionsMu sync.Mutex
+	sessions   map[rpc.ID]*relaySession
+}
+
+// NewPrivateRelayAPI creates a new transaction relay API.
+func NewPrivateRelayAPI(b Backend) *PrivateRelayAPI {
+	metadataCache, _ := lru.New(tokenMetadataCacheLimit)
+	api := &PrivateRelayAPI{
+		b:             b,
+		metadataCache: metadataCache,
+		sessions:      make(map[rpc.ID]*relaySession),
+	}
+	go api.timeoutLoop()
+
+	return api
+}
+
+// tokenMetadata retrieves the metadata of the given token, serving it from the
+// cache if available. Only the metadata of tokens reporting their decimals is
+// cached, so addresses without a token deployed are looked up again.
+func (s *PrivateRelayAPI) tokenMetadata(caller *contractCaller, token common.Address) (*tokenMetadata, error) {
+	if metadata, ok := s.metadataCache.Get(token); ok {
+		return metadata.(*tokenMetadata), nil
+	}
+	metadata := new(tokenMetadata)
+
+	decimals, err := caller.callUint256(token, common.CopyBytes(decimalsSelector))
+	if err == errCallTimeout {
+		return nil, err
+	}
+	if err == nil && decimals.IsUint64() && decimals.Uint64() <= math.MaxUint8 {
+		value := hexutil.Uint(decimals.Uint64())
+		metadata.decimals = &value
+	}
+	if metadata.name, err = caller.tokenString(token, nameSelector); err == errCallTimeout {
+		return nil, err
+	}
+	if metadata.symbol, err = caller.tokenString(token, symbolSelector); err == errCallTimeout {
+		return nil, err
+	}
+	if metadata.decimals != nil {
+		s.metadataCache.Add(token, metadata)
+	}
+	return metadata, nil
+}
+
+// BalanceQuery selects the balances to retrieve for a single holder. The token
+// and spender are optional, without a token only the ether balance is returned.
+type BalanceQuery struct {
+	Token   *common.Address `json:"token"`
+	Holder  common.Address  `json:"holder"`
+	Spender *common.Address `json:"spender"`
+}
+
+// BalanceResult contains the balances retrieved for a single BalanceQuery. If
+// a token call failed, the error is reported and the remaining token fields
+// are omitted. The token metadata is omitted if the token doesn't implement it.
+type BalanceResult struct {
+	Token        *common.Address `json:"token,omitempty"`
+	Holder       common.Address  `json:"holder"`
+	Spender      *common.Address `json:"spender,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Symbol       string          `json:"symbol,omitempty"`
+	Decimals     *hexutil.Uint   `json:"decimals,omitempty"`
+	Balance      *hexutil.Big    `json:"balance"`
+	TokenBalance *hexutil.Big    `json:"tokenBalance,omitempty"`
+	Allowance    *hexutil.Big    `json:"allowance,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// GetBalances returns the ether balance, and optionally the token metadata,
+// balance and allowance, for each of the given queries. All queries are answered from the
+// same state snapshot of the given block.
+func (s *PrivateRelayAPI) GetBalances(ctx context.Context, blockNr rpc.BlockNumber, queries []BalanceQuery) ([]*BalanceResult, error) {
+	defer relayBalancesTimer.UpdateSince(time.Now())
+
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	caller, err := newContractCaller(ctx, s.b, statedb, header)
+	if err != nil {
+		return nil, err
+	}
+	defer caller.close()
+
+	results := make([]*BalanceResult, len(queries))
+	for i, query := range queries {
+		result := &BalanceResult{
+			Token:   query.Token,
+			Holder:  query.Holder,
+			Spender: query.Spender,
+			Balance: (*hexutil.Big)(statedb.GetBalance(query.Holder)),
+		}
+		results[i] = result
+
+		if query.Token == nil {
+			continue
+		}
+		metadata, err := s.tokenMetadata(caller, *query.Token)
+		if err != nil {
+			relayTimeoutMeter.Mark(1)
+			return nil, err
+		}
+		result.Name, result.Symbol, result.Decimals = metadata.name, metadata.symbol, metadata.decimals
+
+		balance, err := caller.tokenBalance(*query.Token, query.Holder)
+		if err == errCallTimeout {
+			relayTimeoutMeter.Mark(1)
+			return nil, err
+		}
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		result.TokenBalance = (*hexutil.Big)(balance)
+
+		if query.Spender == nil {
+			continue
+		}
+		allowance, err := caller.tokenAllowance(*query.Token, query.Holder, *query.Spender)
+		if err == errCallTimeout {
+			relayTimeoutMeter.Mark(1)
+			return nil, err
+		}
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		result.Allowance = (*hexutil.Big)(allowance)
+	}
+	return results, statedb.Error()
+}
+
+// PermitResult is the outcome of an EIP-2612 permit validation.
+type PermitResult struct {
+	Valid     bool         `json:"valid"`
+	Allowance *hexutil.Big `json:"allowance,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// CheckPermit simulates submitting the given EIP-2612 permit to the token at
+// the given block and verifies that it sets the allowance of the spender to
+// the permitted value. If the permit call reverts, its revert reason is
+// reported. A token accepting the call without updating the allowance (e.g.
+// through a fallback function) is reported as invalid too.
+func (s *PrivateRelayAPI) CheckPermit(ctx context.Context, token, owner, spender common.Address, value, deadline hexutil.Big, sigV hexutil.Uint, sigR, sigS common.Hash, blockNr rpc.BlockNumber) (*PermitResult, error) {
+	defer relayPermitTimer.UpdateSince(time.Now())
+
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	caller, err := newContractCaller(ctx, s.b, statedb, header)
+	if err != nil {
+		return nil, err
+	}
+	defer caller.close()
+
+	input := append(common.CopyBytes(permitSelector), common.LeftPadBytes(owner.Bytes(), 32)...)
+	input = append(input, common.LeftPadBytes(spender.Bytes(), 32)...)
+	input = append(input, math.PaddedBigBytes(value.ToInt(), 32)...)
+	input = append(input, math.PaddedBigBytes(deadline.ToInt(), 32)...)
+	input = append(input, math.PaddedBigBytes(new(big.Int).SetUint64(uint64(sigV)), 32)...)
+	input = append(input, sigR.Bytes()...)
+	input = append(input, sigS.Bytes()...)
+
+	ret, err := caller.execute(token, input)
+	if err == errCallTimeout {
+		relayTimeoutMeter.Mark(1)
+		return nil, err
+	}
+	if err != nil {
+		if reason, ok := revertReason(ret); ok {
+			return &PermitResult{Error: reason}, nil
+		}
+		return &PermitResult{Error: err.Error()}, nil
+	}
+	allowance, err := caller.tokenAllowance(token, owner, spender)
+	if err == errCallTimeout {
+		relayTimeoutMeter.Mark(1)
+		return nil, err
+	}
+	if err != nil {
+		return &PermitResult{Error: err.Error()}, nil
+	}
+	result := &PermitResult{
+		Valid:     allowance.Cmp(value.ToInt()) == 0,
+		Allowance: (*hexutil.Big)(allowance),
+	}
+	if !result.Valid {
+		result.Error = "allowance not updated by permit"
+	}
+	return result, nil
+}
+
+// TokenBalanceAt returns the ERC-20 token balance of the given holder at the
+// given block.
+func (s *PrivateRelayAPI) TokenBalanceAt(ctx context.Context, token, holder common.Address, blockNr rpc.BlockNumber) (*hexutil.Big, error) {
+	defer relayTokenBalanceTimer.UpdateSince(time.Now())
+
+	balances, err := s.tokenBalancesAt(ctx, token, []common.Address{holder}, blockNr)
+	if err != nil {
+		return nil, err
+	}
+	return balances[0], nil
+}
+
+// TokenBalancesAt returns the ERC-20 token balances of the given holders at
+// the given block. All balances are read from the same state snapshot.
+func (s *PrivateRelayAPI) TokenBalancesAt(ctx context.Context, token common.Address, holders []common.Address, blockNr rpc.BlockNumber) ([]*hexutil.Big, error) {
+	defer relayTokenBalancesTimer.UpdateSince(time.Now())
+
+	return s.tokenBalancesAt(ctx, token, holders, blockNr)
+}
+
+// tokenBalancesAt retrieves the token balances for TokenBalanceAt and
+// TokenBalancesAt, leaving the timing of the call to them.
+func (s *PrivateRelayAPI) tokenBalancesAt(ctx context.Context, token common.Address, holders []common.Address, blockNr rpc.BlockNumber) ([]*hexutil.Big, error) {
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	caller, err := newContractCaller(ctx, s.b, statedb, header)
+	if err != nil {
+		return nil, err
+	}
+	defer caller.close()
+
+	balances := make([]*hexutil.Big, len(holders))
+	for i, holder := range holders {
+		balance, err := caller.tokenBalance(token, holder)
+		if err != nil {
+			if err == errCallTimeout {
+				relayTimeoutMeter.Mark(1)
+			}
+			return nil, err
+		}
+		balances[i] = (*hexutil.Big)(balance)
+	}
+	return balances, statedb.Error()
+}
+
+// ComputeProxyAddress computes the address at which the given proxy factory
+// deploys a proxy for the given master copy, initializer and salt nonce using
+// createProxyWithNonce, like gnosis_predictSafeAddress. If verify is set, the
+// deployment is also simulated on the state of the given block and its outcome
+// compared to the computed address.
+func (s *PrivateRelayAPI) ComputeProxyAddress(ctx context.Context, factory, masterCopy common.Address, initializer hexutil.Bytes, saltNonce *hexutil.Big, blockNr rpc.BlockNumber, verify *bool) (*SafeAddressPrediction, error) {
+	defer relayProxyAddressTimer.UpdateSince(time.Now())
+
+	result, err := safeAddressPrediction(ctx, s.b, factory, masterCopy, initializer, saltNonce, blockNr, verify != nil && *verify)
+	if err == errCallTimeout {
+		relayTimeoutMeter.Mark(1)
+	}
+	return result, err
+}
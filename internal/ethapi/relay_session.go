@@ -0,0 +1,252 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// sessionTimeout is the time after which an unused session is discarded.
+	sessionTimeout = 5 * time.Minute
+
+	// sessionCallTimeout is the time allowed for a single call in a session.
+	sessionCallTimeout = 5 * time.Second
+
+	// maxSessions is the maximum number of sessions held open at once. The
+	// limit is shared by all clients of the relay namespace.
+	maxSessions = 128
+
+	// maxSessionSnapshots is the maximum number of snapshots per session.
+	maxSessionSnapshots = 64
+)
+
+var (
+	errSessionNotFound  = errors.New("session not found")
+	errTooManySessions  = errors.New("too many open sessions")
+	errTooManySnapshots = errors.New("too many session snapshots")
+	errSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// relaySession is a simulation session accumulating the state changes of all
+// calls executed in it on top of the state of a single block.
+type relaySession struct {
+	mu        sync.Mutex
+	state     *state.StateDB
+	header    *types.Header
+	overrides *BlockOverrides // block environment overrides of all calls
+	snapshots []*state.StateDB
+	deadline  *time.Timer // session is discarded when the deadline fires
+}
+
+// SessionCallResult is the outcome of a call executed within a session.
+type SessionCallResult struct {
+	ReturnValue hexutil.Bytes  `json:"returnValue"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed"`
+	Failed      bool           `json:"failed"`
+}
+
+// timeoutLoop runs every 5 minutes and discards sessions that have not been
+// used within the session timeout.
+func (s *PrivateRelayAPI) timeoutLoop() {
+	ticker := time.NewTicker(sessionTimeout)
+	for {
+		<-ticker.C
+		s.evictSessions()
+	}
+}
+
+// evictSessions discards all sessions whose deadline has fired.
+func (s *PrivateRelayAPI) evictSessions() {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	for id, session := range s.sessions {
+		select {
+		case <-session.deadline.C:
+			delete(s.sessions, id)
+		default:
+			continue
+		}
+	}
+	relaySessionsGauge.Update(int64(len(s.sessions)))
+}
+
+// session retrieves the session with the given id and extends its deadline.
+// The returned session is locked, the caller is responsible for unlocking it.
+func (s *PrivateRelayAPI) session(id rpc.ID) (*relaySession, error) {
+	s.sessionsMu.Lock()
+	session, ok := s.sessions[id]
+	if ok {
+		// receive timer value and reset timer
+		if !session.deadline.Stop() {
+			select {
+			case <-session.deadline.C:
+			default:
+			}
+		}
+		session.deadline.Reset(sessionTimeout)
+	}
+	s.sessionsMu.Unlock()
+
+	if !ok {
+		return nil, errSessionNotFound
+	}
+	session.mu.Lock()
+	return session, nil
+}
+
+// CreateSession opens a simulation session on top of the state of the given
+// block. Calls executed in the session build on each other's state changes,
+// which are never written to the chain. The optional block overrides set the
+// block environment all calls of the session are executed in. Sessions not
+// used for 5 minutes are discarded.
+//
+// Sessions are a shared resource: their number is limited across all clients
+// and the memory held by a session is only bounded by the calls executed in
+// it. The relay namespace must therefore not be exposed to untrusted clients.
+func (s *PrivateRelayAPI) CreateSession(ctx context.Context, blockNr rpc.BlockNumber, blockOverrides *BlockOverrides) (rpc.ID, error) {
+	defer relayCreateSessionTimer.UpdateSince(time.Now())
+
+	statedb, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return "", err
+	}
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if len(s.sessions) >= maxSessions {
+		return "", errTooManySessions
+	}
+	id := rpc.NewID()
+	s.sessions[id] = &relaySession{
+		state:     statedb.Copy(),
+		header:    header,
+		overrides: blockOverrides,
+		deadline:  time.NewTimer(sessionTimeout),
+	}
+	relaySessionsGauge.Update(int64(len(s.sessions)))
+
+	return id, nil
+}
+
+// SessionCall executes the given call within the session and keeps its state
+// changes. The sender is funded for the duration of the call, afterwards only
+// the ether it actually spent is deducted from its original balance. Unlike
+// eth_call, the gas price defaults to zero so unpriced calls don't alter the
+// sender's balance.
+func (s *PrivateRelayAPI) SessionCall(ctx context.Context, id rpc.ID, args CallArgs) (*SessionCallResult, error) {
+	defer relaySessionCallTimer.UpdateSince(time.Now())
+
+	session, err := s.session(id)
+	if err != nil {
+		return nil, err
+	}
+	defer session.mu.Unlock()
+
+	gas := uint64(args.Gas)
+	if gas == 0 {
+		gas = math.MaxUint64 / 2
+	}
+	msg := types.NewMessage(args.sender(s.b), args.To, 0, args.Value.ToInt(), gas, args.GasPrice.ToInt(), args.Data, false)
+
+	ctx, cancel := context.WithTimeout(ctx, sessionCallTimeout)
+	defer cancel()
+
+	start := time.Now()
+	res, gasUsed, failed, err := applyCall(ctx, s.b, msg, session.state, session.header, session.overrides)
+	relaySessionExecTimer.UpdateSince(start)
+
+	if err != nil {
+		if err == errCallTimeout {
+			relayTimeoutMeter.Mark(1)
+		}
+		return nil, err
+	}
+	relaySessionGasHist.Update(int64(gasUsed))
+	if failed {
+		relaySessionRevertMeter.Mark(1)
+	}
+	return &SessionCallResult{
+		ReturnValue: res,
+		GasUsed:     hexutil.Uint64(gasUsed),
+		Failed:      failed,
+	}, nil
+}
+
+// SessionSnapshot records the current state of the session and returns an
+// identifier that can be passed to SessionRevert.
+func (s *PrivateRelayAPI) SessionSnapshot(id rpc.ID) (hexutil.Uint, error) {
+	defer relaySessionSnapTimer.UpdateSince(time.Now())
+
+	session, err := s.session(id)
+	if err != nil {
+		return 0, err
+	}
+	defer session.mu.Unlock()
+
+	if len(session.snapshots) >= maxSessionSnapshots {
+		return 0, errTooManySnapshots
+	}
+	session.snapshots = append(session.snapshots, session.state.Copy())
+	return hexutil.Uint(len(session.snapshots) - 1), nil
+}
+
+// SessionRevert restores the session to the given snapshot. Snapshots taken
+// after it are discarded, the snapshot itself can be reverted to again.
+func (s *PrivateRelayAPI) SessionRevert(id rpc.ID, snapshot hexutil.Uint) error {
+	defer relaySessionRevertTimer.UpdateSince(time.Now())
+
+	session, err := s.session(id)
+	if err != nil {
+		return err
+	}
+	defer session.mu.Unlock()
+
+	if int(snapshot) >= len(session.snapshots) {
+		return errSnapshotNotFound
+	}
+	session.state = session.snapshots[snapshot].Copy()
+	session.snapshots = session.snapshots[:snapshot+1]
+	return nil
+}
+
+// CloseSession discards the given session. It returns true if the session
+// existed.
+func (s *PrivateRelayAPI) CloseSession(id rpc.ID) bool {
+	defer relayCloseSessionTimer.UpdateSince(time.Now())
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	session, ok := s.sessions[id]
+	if ok {
+		session.deadline.Stop()
+		delete(s.sessions, id)
+		relaySessionsGauge.Update(int64(len(s.sessions)))
+	}
+	return ok
+}
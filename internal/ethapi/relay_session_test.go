@@ -0,0 +1,126 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testCounterCode increments the counter in storage slot 0 and returns its new
+// value.
+var testCounterCode = common.FromHex("6000546001018060005560005260206000f3")
+
+func TestSessionSnapshotRevert(t *testing.T) {
+	var (
+		counter = common.HexToAddress("0xc0")
+		sender  = common.HexToAddress("0x5e")
+	)
+	api := NewPrivateRelayAPI(newTestBackend(t, core.GenesisAlloc{counter: {Code: testCounterCode}}))
+
+	id, err := api.CreateSession(context.Background(), rpc.LatestBlockNumber, nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	call := func(want int64) {
+		t.Helper()
+
+		res, err := api.SessionCall(context.Background(), id, CallArgs{From: sender, To: &counter})
+		if err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+		if res.Failed {
+			t.Fatalf("call reverted")
+		}
+		if have := new(big.Int).SetBytes(res.ReturnValue); have.Int64() != want {
+			t.Fatalf("counter mismatch: have %d, want %d", have, want)
+		}
+	}
+	call(1)
+	snapshot, err := api.SessionSnapshot(id)
+	if err != nil {
+		t.Fatalf("failed to snapshot session: %v", err)
+	}
+	call(2)
+	call(3)
+
+	// Reverting discards the calls made since the snapshot, repeatedly
+	for i := 0; i < 2; i++ {
+		if err := api.SessionRevert(id, snapshot); err != nil {
+			t.Fatalf("failed to revert session: %v", err)
+		}
+		call(2)
+	}
+	if err := api.SessionRevert(id, snapshot+1); err != errSnapshotNotFound {
+		t.Fatalf("unknown snapshot error mismatch: have %v, want %v", err, errSnapshotNotFound)
+	}
+	// The session state must never leak into the chain state
+	statedb, _, _ := api.b.StateAndHeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+	if value := statedb.GetState(counter, common.Hash{}); value != (common.Hash{}) {
+		t.Fatalf("chain state modified: counter %x", value)
+	}
+	if !api.CloseSession(id) {
+		t.Fatalf("failed to close session")
+	}
+	if _, err := api.SessionCall(context.Background(), id, CallArgs{From: sender, To: &counter}); err != errSessionNotFound {
+		t.Fatalf("closed session error mismatch: have %v, want %v", err, errSessionNotFound)
+	}
+}
+
+func TestSessionEviction(t *testing.T) {
+	api := NewPrivateRelayAPI(newTestBackend(t, nil))
+
+	expired, err := api.CreateSession(context.Background(), rpc.LatestBlockNumber, nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	active, err := api.CreateSession(context.Background(), rpc.LatestBlockNumber, nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	used, err := api.CreateSession(context.Background(), rpc.LatestBlockNumber, nil)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	// Let the deadlines of two sessions pass, then use one of them again
+	api.sessionsMu.Lock()
+	api.sessions[expired].deadline.Reset(0)
+	api.sessions[used].deadline.Reset(0)
+	api.sessionsMu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := api.SessionSnapshot(used); err != nil {
+		t.Fatalf("failed to use session: %v", err)
+	}
+	api.evictSessions()
+
+	if _, err := api.SessionSnapshot(expired); err != errSessionNotFound {
+		t.Errorf("expired session error mismatch: have %v, want %v", err, errSessionNotFound)
+	}
+	for _, id := range []rpc.ID{active, used} {
+		if _, err := api.SessionSnapshot(id); err != nil {
+			t.Errorf("session %s evicted: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testPermitTokenCode stores the value of any permit call as the allowance in
+// storage slot 0 and answers every other call with that allowance.
+var testPermitTokenCode = common.FromHex("3660e41460125760005460005260206000f35b60443560005500")
+
+func TestGetBalances(t *testing.T) {
+	var (
+		token    = common.HexToAddress("0x70")
+		nontoken = common.HexToAddress("0x71")
+		holder   = common.HexToAddress("0x01")
+		spender  = common.HexToAddress("0x02")
+	)
+	api := NewPrivateRelayAPI(newTestBackend(t, core.GenesisAlloc{
+		holder: {Balance: big.NewInt(3)},
+		token: {Code: testReturnCode(
+			testReturn{selector: nameSelector, data: abiString("Test Token")},
+			testReturn{selector: symbolSelector, data: abiString("TST")},
+			testReturn{selector: decimalsSelector, data: abiWord(big.NewInt(18))},
+			testReturn{selector: balanceOfSelector, data: abiWord(big.NewInt(5))},
+			testReturn{selector: allowanceSelector, data: abiWord(big.NewInt(7))},
+		)},
+	}))
+	results, err := api.GetBalances(context.Background(), rpc.LatestBlockNumber, []BalanceQuery{
+		{Holder: holder},
+		{Token: &nontoken, Holder: holder, Spender: &spender},
+		{Token: &token, Holder: holder, Spender: &spender},
+	})
+	if err != nil {
+		t.Fatalf("failed to retrieve balances: %v", err)
+	}
+	decimals := hexutil.Uint(18)
+	want := []*BalanceResult{
+		{Holder: holder, Balance: (*hexutil.Big)(big.NewInt(3))},
+		{Token: &nontoken, Holder: holder, Spender: &spender, Balance: (*hexutil.Big)(big.NewInt(3)), Error: errInvalidReturnData.Error()},
+		{
+			Token: &token, Holder: holder, Spender: &spender, Name: "Test Token", Symbol: "TST", Decimals: &decimals,
+			Balance: (*hexutil.Big)(big.NewInt(3)), TokenBalance: (*hexutil.Big)(big.NewInt(5)), Allowance: (*hexutil.Big)(big.NewInt(7)),
+		},
+	}
+	// A failing token call is only reported for its own query
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("balances mismatch: have %s, want %s", spew.Sdump(results), spew.Sdump(want))
+	}
+}
+
+func TestTokenMetadata(t *testing.T) {
+	var (
+		str     = common.HexToAddress("0x70")
+		fixed   = common.HexToAddress("0x71")
+		missing = common.HexToAddress("0x72")
+		holder  = common.HexToAddress("0x01")
+	)
+	api := NewPrivateRelayAPI(newTestBackend(t, core.GenesisAlloc{
+		str: {Code: testReturnCode(
+			testReturn{selector: nameSelector, data: abiString("Test Token")},
+			testReturn{selector: symbolSelector, data: abiString("TST")},
+			testReturn{selector: decimalsSelector, data: abiWord(big.NewInt(18))},
+			testReturn{selector: balanceOfSelector, data: abiWord(new(big.Int))},
+		)},
+		// Early tokens return their name and symbol as bytes32
+		fixed: {Code: testReturnCode(
+			testReturn{selector: nameSelector, data: common.RightPadBytes([]byte("Maker"), 32)},
+			testReturn{selector: symbolSelector, data: common.RightPadBytes([]byte("MKR"), 32)},
+			testReturn{selector: decimalsSelector, data: abiWord(big.NewInt(18))},
+			testReturn{selector: balanceOfSelector, data: abiWord(new(big.Int))},
+		)},
+		missing: {Code: testReturnCode(
+			testReturn{selector: balanceOfSelector, data: abiWord(new(big.Int))},
+		)},
+	}))
+	results, err := api.GetBalances(context.Background(), rpc.LatestBlockNumber, []BalanceQuery{
+		{Token: &str, Holder: holder},
+		{Token: &fixed, Holder: holder},
+		{Token: &missing, Holder: holder},
+	})
+	if err != nil {
+		t.Fatalf("failed to retrieve balances: %v", err)
+	}
+	decimals := hexutil.Uint(18)
+	tests := []struct {
+		name, symbol string
+		decimals     *hexutil.Uint
+		cached       bool
+	}{
+		{"Test Token", "TST", &decimals, true},
+		{"Maker", "MKR", &decimals, true},
+		{"", "", nil, false},
+	}
+	for i, tt := range tests {
+		res := results[i]
+		if res.Error != "" {
+			t.Errorf("test %d: unexpected error: %s", i, res.Error)
+		}
+		if res.Name != tt.name || res.Symbol != tt.symbol {
+			t.Errorf("test %d: metadata mismatch: have %q %q, want %q %q", i, res.Name, res.Symbol, tt.name, tt.symbol)
+		}
+		if !reflect.DeepEqual(res.Decimals, tt.decimals) {
+			t.Errorf("test %d: decimals mismatch: have %v, want %v", i, res.Decimals, tt.decimals)
+		}
+		// Tokens not reporting their decimals must be looked up again
+		if cached := api.metadataCache.Contains(*res.Token); cached != tt.cached {
+			t.Errorf("test %d: cache mismatch: have %v, want %v", i, cached, tt.cached)
+		}
+	}
+}
+
+func TestTokenBalancesAt(t *testing.T) {
+	var (
+		token  = common.HexToAddress("0x70")
+		holder = common.HexToAddress("0x01")
+	)
+	api := NewPrivateRelayAPI(newTestBackend(t, core.GenesisAlloc{
+		token: {Code: testReturnCode(testReturn{selector: balanceOfSelector, data: abiWord(big.NewInt(5))})},
+	}))
+	balance, err := api.TokenBalanceAt(context.Background(), token, holder, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("failed to retrieve balance: %v", err)
+	}
+	if balance.ToInt().Int64() != 5 {
+		t.Errorf("balance mismatch: have %v, want 5", balance)
+	}
+	balances, err := api.TokenBalancesAt(context.Background(), token, []common.Address{holder, holder}, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("failed to retrieve balances: %v", err)
+	}
+	if len(balances) != 2 || balances[0].ToInt().Int64() != 5 || balances[1].ToInt().Int64() != 5 {
+		t.Errorf("balances mismatch: have %v, want [5 5]", balances)
+	}
+	// Addresses without a token deployed fail the whole query
+	if _, err := api.TokenBalancesAt(context.Background(), holder, []common.Address{holder}, rpc.LatestBlockNumber); err != errInvalidReturnData {
+		t.Errorf("missing token error mismatch: have %v, want %v", err, errInvalidReturnData)
+	}
+}
+
+func TestCheckPermit(t *testing.T) {
+	var (
+		valid    = common.HexToAddress("0x7a")
+		reverted = common.HexToAddress("0x7b")
+		ignored  = common.HexToAddress("0x7c")
+		owner    = common.HexToAddress("0x01")
+		spender  = common.HexToAddress("0x02")
+		value    = hexutil.Big(*big.NewInt(1000))
+	)
+	api := NewPrivateRelayAPI(newTestBackend(t, core.GenesisAlloc{
+		valid: {Code: testPermitTokenCode},
+		reverted: {Code: testReturnCode(testReturn{
+			selector: permitSelector,
+			data:     abiEncode(revertSelector, abiString("Permit: invalid signature")),
+			revert:   true,
+		})},
+		ignored: {Code: testReturnCode(
+			testReturn{selector: permitSelector},
+			testReturn{selector: allowanceSelector, data: abiWord(new(big.Int))},
+		)},
+	}))
+	tests := []struct {
+		token common.Address
+		want  PermitResult
+	}{
+		{valid, PermitResult{Valid: true, Allowance: &value}},
+		{reverted, PermitResult{Error: "Permit: invalid signature"}},
+		{ignored, PermitResult{Allowance: new(hexutil.Big), Error: "allowance not updated by permit"}},
+	}
+	for i, tt := range tests {
+		res, err := api.CheckPermit(context.Background(), tt.token, owner, spender, value, hexutil.Big(*big.NewInt(1)), 27, common.Hash{1}, common.Hash{2}, rpc.LatestBlockNumber)
+		if err != nil {
+			t.Fatalf("test %d: failed to check permit: %v", i, err)
+		}
+		if res.Valid != tt.want.Valid || res.Error != tt.want.Error {
+			t.Errorf("test %d: result mismatch: have %v %q, want %v %q", i, res.Valid, res.Error, tt.want.Valid, tt.want.Error)
+		}
+		if (res.Allowance == nil) != (tt.want.Allowance == nil) || (res.Allowance != nil && res.Allowance.ToInt().Cmp(tt.want.Allowance.ToInt()) != 0) {
+			t.Errorf("test %d: allowance mismatch: have %v, want %v", i, res.Allowance, tt.want.Allowance)
+		}
+	}
+	// Checking a permit must not change the allowance in the chain state
+	statedb, _, _ := api.b.StateAndHeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+	if allowance := statedb.GetState(valid, common.Hash{}); allowance != (common.Hash{}) {
+		t.Fatalf("chain state modified: allowance %x", allowance)
+	}
+}
@@ -24,9 +24,11 @@ var Modules = map[string]string{
 	"ethash":     Ethash_JS,
 	"debug":      Debug_JS,
 	"eth":        Eth_JS,
+	"gnosis":     Gnosis_JS,
 	"miner":      Miner_JS,
 	"net":        Net_JS,
 	"personal":   Personal_JS,
+	"relay":      Relay_JS,
 	"rpc":        RPC_JS,
 	"shh":        Shh_JS,
 	"swarmfs":    SWARMFS_JS,
@@ -462,6 +464,12 @@ web3._extend({
 			params: 1,
 			inputFormatter: [web3._extend.formatters.inputTransactionFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'callMany',
+			call: 'eth_callMany',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
 		new web3._extend.Method({
 			name: 'submitTransaction',
 			call: 'eth_submitTransaction',
@@ -505,6 +513,43 @@ web3._extend({
 });
 `
 
+const Gnosis_JS = `
+web3._extend({
+	property: 'gnosis',
+	methods: [
+		new web3._extend.Method({
+			name: 'getSafeInfo',
+			call: 'gnosis_getSafeInfo',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getBalanceHistory',
+			call: 'gnosis_getBalanceHistory',
+			params: 5,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.utils.toHex, web3._extend.utils.toHex, web3._extend.utils.toHex]
+		}),
+		new web3._extend.Method({
+			name: 'getModules',
+			call: 'gnosis_getModules',
+			params: 4,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputAddressFormatter, web3._extend.utils.toHex, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'predictSafeAddress',
+			call: 'gnosis_predictSafeAddress',
+			params: 5,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputAddressFormatter, null, web3._extend.utils.fromDecimal, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getNonceConflicts',
+			call: 'gnosis_getNonceConflicts',
+			params: 0
+		}),
+	]
+});
+`
+
 const Miner_JS = `
 web3._extend({
 	property: 'miner',
@@ -666,6 +711,71 @@ web3._extend({
 });
 `
 
+const Relay_JS = `
+web3._extend({
+	property: 'relay',
+	methods: [
+		new web3._extend.Method({
+			name: 'getBalances',
+			call: 'relay_getBalances',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'checkPermit',
+			call: 'relay_checkPermit',
+			params: 9,
+			inputFormatter: [null, null, null, null, null, null, null, null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'tokenBalanceAt',
+			call: 'relay_tokenBalanceAt',
+			params: 3,
+			inputFormatter: [null, null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'tokenBalancesAt',
+			call: 'relay_tokenBalancesAt',
+			params: 3,
+			inputFormatter: [null, null, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'computeProxyAddress',
+			call: 'relay_computeProxyAddress',
+			params: 6,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputAddressFormatter, null, web3._extend.utils.fromDecimal, web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'createSession',
+			call: 'relay_createSession',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'sessionCall',
+			call: 'relay_sessionCall',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputCallFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'sessionSnapshot',
+			call: 'relay_sessionSnapshot',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'sessionRevert',
+			call: 'relay_sessionRevert',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'closeSession',
+			call: 'relay_closeSession',
+			params: 1
+		}),
+	]
+});
+`
+
 const TxPool_JS = `
 web3._extend({
 	property: 'txpool',